@@ -10,15 +10,20 @@ require (
 	github.com/cheggaaa/pb/v3 v3.0.8
 	github.com/chromedp/cdproto v0.0.0-20210323015217-0942afbea50e
 	github.com/chromedp/chromedp v0.6.12
-	github.com/cloudflare/backoff v0.0.0-20161212185259-647f3cdfc87a
 	github.com/dgraph-io/badger/v3 v3.2103.0
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gorilla/mux v1.8.0
 	github.com/konoui/alfred-bookmarks v0.4.2
+	github.com/ledongthuc/pdf v0.0.0-20220302134840-0c2507a12d80
 	github.com/peterbourgon/ff/v3 v3.0.0
 	github.com/peterh/liner v1.2.1
 	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d
+	github.com/yuin/goldmark v1.2.1
 	go.uber.org/ratelimit v0.2.0
 	go.uber.org/zap v1.17.0
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
 	golang.org/x/sys v0.0.0-20210616094352-59db8d763f22 // indirect
+	golang.org/x/text v0.3.4
 )
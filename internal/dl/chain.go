@@ -0,0 +1,238 @@
+package dl
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Condition decides whether the next fetcher of a Chain should be tried,
+	// given the outcome of the previous one.
+	Condition func(res *Result, err error) bool
+
+	// ChainStep is a fetcher of a Chain along with the condition under which it is used.
+	// The condition of the first step is ignored.
+	ChainStep struct {
+		Name    string
+		Fetcher Fetcher
+		When    Condition
+	}
+
+	// Chain tries its fetchers in order, falling back to the next one as long as
+	// the step's condition holds for the outcome of the previous fetcher.
+	Chain struct {
+		steps []ChainStep
+	}
+)
+
+const (
+	// DefaultChainSpec keeps the behaviour go-nate always had: plain HTTP first,
+	// then the headless browser whenever HTTP didn't answer with 200.
+	DefaultChainSpec = "http,chrome:non200"
+
+	_chainStepSeparator = ","
+	_chainCondSeparator = "+"
+)
+
+var (
+	_errEmptyChain = errors.New("fetcher chain is empty")
+)
+
+func NewChain(steps ...ChainStep) (*Chain, error) {
+	if len(steps) == 0 {
+		return nil, _errEmptyChain
+	}
+
+	return &Chain{steps: steps}, nil
+}
+
+// Fetch implements Fetcher. When a fallback fetcher fails, the latest
+// successful result is returned instead, so a non-200 page is still better than nothing.
+// Neither "304 Not Modified" response nor the one too large to download is fallen back from.
+func (c *Chain) Fetch(ctx context.Context, url string) (*Result, error) {
+	var (
+		best, last *Result
+		lastErr    error
+		steps      []Step
+	)
+
+	for i, s := range c.steps {
+		if i > 0 && (s.When == nil || !s.When(last, lastErr)) {
+			continue
+		}
+
+		last, lastErr = s.Fetcher.Fetch(ctx, url)
+
+		step := Step{Loader: s.Name, Err: lastErr}
+		if last != nil {
+			if last.Loader == "" {
+				last.Loader = s.Name
+			}
+			step.StatusCode = last.StatusCode
+			step.Attempts = last.Attempts
+			best = last
+		}
+		var retryErr *RetryError
+		if errors.As(lastErr, &retryErr) {
+			step.Attempts = retryErr.Attempts
+		}
+		steps = append(steps, step)
+
+		// nothing to fall back for, the content is either the same as it was before or not to be downloaded
+		if ctx.Err() != nil || (last != nil && (last.NotModified() || last.TooLarge)) {
+			break
+		}
+	}
+
+	if best == nil {
+		return nil, lastErr
+	}
+	best.Steps = steps
+
+	return best, nil
+}
+
+// OnError holds when the previous fetcher failed.
+func OnError() Condition {
+	return func(res *Result, err error) bool {
+		return err != nil
+	}
+}
+
+// OnNonOK holds when the previous fetcher responded with anything but 200.
+func OnNonOK() Condition {
+	return func(res *Result, err error) bool {
+		return res != nil && res.StatusCode != http.StatusOK
+	}
+}
+
+// OnStatus holds when the previous fetcher responded with one of the codes.
+func OnStatus(codes ...int) Condition {
+	return func(res *Result, err error) bool {
+		if res == nil {
+			return false
+		}
+		for _, c := range codes {
+			if res.StatusCode == c {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// OnEmptyBody holds when the previous fetcher responded without a body.
+func OnEmptyBody() Condition {
+	return OnShortContent(1)
+}
+
+// OnShortContent holds when the previous fetcher responded with less than min
+// bytes of non-whitespace content.
+func OnShortContent(min int) Condition {
+	return func(res *Result, err error) bool {
+		return res != nil && len(strings.TrimSpace(string(res.Body))) < min
+	}
+}
+
+// Any holds when at least one of the conditions holds.
+func Any(conds ...Condition) Condition {
+	return func(res *Result, err error) bool {
+		for _, c := range conds {
+			if c(res, err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ParseChain builds a Chain from the spec, looking fetchers up in the registry by name.
+// The spec is a comma separated list of steps, where each step but the first is
+// followed by its conditions joined with "+", for example:
+//
+//	http,chrome:non200+empty,archive:error+status=404/410+short=512
+//
+// Supported conditions are "error", "non200", "empty", "short=<bytes>"
+// and "status=<code>[/<code>...]".
+func ParseChain(spec string, registry map[string]Fetcher) (*Chain, error) {
+	var steps []ChainStep
+
+	for _, raw := range strings.Split(spec, _chainStepSeparator) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		name, condSpec := raw, ""
+		if idx := strings.Index(raw, ":"); idx >= 0 {
+			name, condSpec = raw[:idx], raw[idx+1:]
+		}
+
+		f, ok := registry[name]
+		if !ok {
+			return nil, errors.Errorf("unknown fetcher %q", name)
+		}
+
+		step := ChainStep{Name: name, Fetcher: f}
+		if len(steps) > 0 {
+			if condSpec == "" {
+				return nil, errors.Errorf("fetcher %q has no fallback condition", name)
+			}
+			cond, err := parseConditions(condSpec)
+			if err != nil {
+				return nil, errors.Wrapf(err, "fetcher %q", name)
+			}
+			step.When = cond
+		} else if condSpec != "" {
+			return nil, errors.Errorf("first fetcher %q can't have a condition", name)
+		}
+
+		steps = append(steps, step)
+	}
+
+	return NewChain(steps...)
+}
+
+func parseConditions(spec string) (Condition, error) {
+	var conds []Condition
+
+	for _, c := range strings.Split(spec, _chainCondSeparator) {
+		name, arg := c, ""
+		if idx := strings.Index(c, "="); idx >= 0 {
+			name, arg = c[:idx], c[idx+1:]
+		}
+
+		switch name {
+		case "error":
+			conds = append(conds, OnError())
+		case "non200":
+			conds = append(conds, OnNonOK())
+		case "empty":
+			conds = append(conds, OnEmptyBody())
+		case "short":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid content length in condition %q", c)
+			}
+			conds = append(conds, OnShortContent(n))
+		case "status":
+			var codes []int
+			for _, s := range strings.Split(arg, "/") {
+				code, err := strconv.Atoi(s)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid status code in condition %q", c)
+				}
+				codes = append(codes, code)
+			}
+			conds = append(conds, OnStatus(codes...))
+		default:
+			return nil, errors.Errorf("unknown condition %q", c)
+		}
+	}
+
+	return Any(conds...), nil
+}
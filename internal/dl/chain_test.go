@@ -0,0 +1,156 @@
+package dl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func respond(code int, body string) Fetcher {
+	return FetcherFunc(func(ctx context.Context, url string) (*Result, error) {
+		return &Result{URL: url, StatusCode: code, Body: []byte(body)}, nil
+	})
+}
+
+func fail(err error) Fetcher {
+	return FetcherFunc(func(ctx context.Context, url string) (*Result, error) {
+		return nil, err
+	})
+}
+
+func TestChainFallback(t *testing.T) {
+	errNet := errors.New("connection reset")
+
+	cases := []struct {
+		name       string
+		spec       string
+		registry   map[string]Fetcher
+		wantLoader string
+		wantCode   int
+		wantSteps  []string
+		wantErr    error
+	}{
+		{
+			name:       "first succeeds",
+			spec:       DefaultChainSpec,
+			registry:   map[string]Fetcher{"http": respond(200, "ok"), "chrome": respond(200, "chrome")},
+			wantLoader: "http",
+			wantCode:   200,
+			wantSteps:  []string{"http"},
+		},
+		{
+			name:       "non200 falls back",
+			spec:       DefaultChainSpec,
+			registry:   map[string]Fetcher{"http": respond(403, ""), "chrome": respond(200, "chrome")},
+			wantLoader: "chrome",
+			wantCode:   200,
+			wantSteps:  []string{"http", "chrome"},
+		},
+		{
+			name:      "non200 doesn't hold for error",
+			spec:      DefaultChainSpec,
+			registry:  map[string]Fetcher{"http": fail(errNet), "chrome": respond(200, "chrome")},
+			wantSteps: []string{"http"},
+			wantErr:   errNet,
+		},
+		{
+			name:       "error falls back",
+			spec:       "http,chrome:error",
+			registry:   map[string]Fetcher{"http": fail(errNet), "chrome": respond(200, "chrome")},
+			wantLoader: "chrome",
+			wantCode:   200,
+			wantSteps:  []string{"http", "chrome"},
+		},
+		{
+			name:       "failed fallback keeps previous result",
+			spec:       DefaultChainSpec,
+			registry:   map[string]Fetcher{"http": respond(404, "not found"), "chrome": fail(errNet)},
+			wantLoader: "http",
+			wantCode:   404,
+			wantSteps:  []string{"http", "chrome"},
+		},
+		{
+			name:       "not modified isn't fallen back from",
+			spec:       DefaultChainSpec,
+			registry:   map[string]Fetcher{"http": respond(http.StatusNotModified, ""), "chrome": respond(200, "chrome")},
+			wantLoader: "http",
+			wantCode:   http.StatusNotModified,
+			wantSteps:  []string{"http"},
+		},
+		{
+			name: "conditions are skipped until one holds",
+			spec: "http,chrome:status=404/410,archive:short=10",
+			registry: map[string]Fetcher{
+				"http":    respond(200, "tiny"),
+				"chrome":  respond(200, "chrome"),
+				"archive": respond(200, "archived content"),
+			},
+			wantLoader: "archive",
+			wantCode:   200,
+			wantSteps:  []string{"http", "archive"},
+		},
+		{
+			name:       "empty body falls back",
+			spec:       "http,chrome:empty",
+			registry:   map[string]Fetcher{"http": respond(200, " \n"), "chrome": respond(200, "chrome")},
+			wantLoader: "chrome",
+			wantCode:   200,
+			wantSteps:  []string{"http", "chrome"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chain, err := ParseChain(c.spec, c.registry)
+			if err != nil {
+				t.Fatalf("ParseChain(%q): %v", c.spec, err)
+			}
+
+			res, err := chain.Fetch(context.Background(), "https://example.com/")
+			if c.wantErr != nil {
+				if !errors.Is(err, c.wantErr) {
+					t.Fatalf("got error %v, want %v", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Loader != c.wantLoader || res.StatusCode != c.wantCode {
+				t.Errorf("got %s %d, want %s %d", res.Loader, res.StatusCode, c.wantLoader, c.wantCode)
+			}
+
+			var steps []string
+			for _, s := range res.Steps {
+				steps = append(steps, s.Loader)
+			}
+			if len(steps) != len(c.wantSteps) {
+				t.Fatalf("got steps %v, want %v", steps, c.wantSteps)
+			}
+			for i := range steps {
+				if steps[i] != c.wantSteps[i] {
+					t.Fatalf("got steps %v, want %v", steps, c.wantSteps)
+				}
+			}
+		})
+	}
+}
+
+func TestParseChainErrors(t *testing.T) {
+	registry := map[string]Fetcher{"http": respond(200, ""), "chrome": respond(200, "")}
+
+	for _, spec := range []string{
+		"",
+		"ftp",
+		"http:error",
+		"http,chrome",
+		"http,chrome:sometimes",
+		"http,chrome:short=many",
+		"http,chrome:status=40x",
+	} {
+		if _, err := ParseChain(spec, registry); err == nil {
+			t.Errorf("ParseChain(%q) succeeded, want error", spec)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package dl
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"github.com/chromedp/cdproto/network"
 	"io"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/chromedp/cdproto/page"
@@ -14,11 +16,19 @@ import (
 )
 
 const (
+	ChromeLoaderName = "chrome"
+
 	_statusErrorTmpl = "failed to retrieve page, status code %d"
 
 	DefaultUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.93 Safari/537.36"
 )
 
+// StatusError is returned when the browser received the page with non-200 status code.
+type StatusError struct {
+	Code   int
+	Header http.Header
+}
+
 type ChromeInstance struct {
 	cfg config
 
@@ -39,14 +49,22 @@ var runner runnerFn = chromedp.RunResponse
 func NewChromeLoader(options ...Option) *ChromeInstance {
 	cfg := config{
 		UserAgent: DefaultUA,
+		Retry:     DefaultRetryPolicy,
+		Limits:    DefaultLimits,
 	}
 	for _, opt := range options {
 		opt(&cfg)
 	}
 
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.UserAgent(cfg.UserAgent),
+		chromedp.UserAgent(cfg.withToken(cfg.UserAgent)),
 	)
+	if cfg.Profiles != nil {
+		// only proxies could be set per host in the browser, it trusts the CAs of the system
+		if pac := cfg.Profiles.PAC(); pac != "" {
+			opts = append(opts, chromedp.Flag("proxy-pac-url", pac))
+		}
+	}
 
 	allocCtx, aCancel := chromedp.NewExecAllocator(context.Background(), opts[:]...)
 	ctx, cCancel := chromedp.NewContext(allocCtx)
@@ -104,6 +122,62 @@ func (bi *ChromeInstance) Get(ctx context.Context, url string) (*http.Response,
 	return bi.get(ctx, url)
 }
 
+// Fetch implements Fetcher by rendering the page in the headless browser. Failed navigations are retried
+// according to the RetryPolicy of the loader, the browser is not held while waiting for the next attempt.
+func (bi *ChromeInstance) Fetch(ctx context.Context, url string) (*Result, error) {
+	return bi.cfg.Retry.Do(ctx, func(ctx context.Context) (*Result, error) {
+		// the browser isn't held while the request waits
+		err := bi.cfg.guard(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		return bi.fetch(ctx, url)
+	})
+}
+
+func (bi *ChromeInstance) fetch(ctx context.Context, url string) (*Result, error) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	res := &Result{
+		URL:        url,
+		FinalURL:   url,
+		Loader:     ChromeLoaderName,
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+	}
+
+	if bi.cfg.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bi.cfg.Limits.Timeout)
+		defer cancel()
+	}
+
+	var str string
+	err := bi.navigate(ctx, url, &str)
+
+	// the page is rendered whatever the status is, so it's still the result
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		res.StatusCode = statusErr.Code
+		res.Header = statusErr.Header
+	} else if err != nil {
+		return nil, err
+	}
+
+	// the body is the DOM serialized by the browser, which is always UTF-8
+	res.Header.Set("Content-Type", "text/html; charset=utf-8")
+	res.Size = int64(len(str))
+	if max := bi.cfg.Limits.MaxBodySize; max > 0 && res.Size > max {
+		res.TooLarge = true
+		return res, nil
+	}
+	res.Body = []byte(str)
+
+	return res, nil
+}
+
 func (bi *ChromeInstance) get(ctx context.Context, url string) (*http.Response, error) {
 	buf, err := bi.download(ctx, url)
 	if err != nil {
@@ -135,6 +209,10 @@ func (bi *ChromeInstance) navigate(ctx context.Context, uri string, str *string)
 				if err != nil {
 					return err
 				}
+				if c := bi.cfg.Credentials; c != nil && len(c.cookies) > 0 {
+					// the browser scopes the cookies to their domains by itself
+					return network.SetCookies(c.ChromeCookies()).Do(ctx)
+				}
 				return nil
 			}),
 			chromedp.Navigate(uri),
@@ -146,7 +224,7 @@ func (bi *ChromeInstance) navigate(ctx context.Context, uri string, str *string)
 		}
 
 		if resp.Status != http.StatusOK {
-			errC <- errors.Errorf(_statusErrorTmpl, resp.Status)
+			errC <- &StatusError{Code: int(resp.Status), Header: responseHeader(resp)}
 
 			return
 		}
@@ -166,3 +244,19 @@ func (bi *ChromeInstance) navigate(ctx context.Context, uri string, str *string)
 
 	return nil
 }
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf(_statusErrorTmpl, e.Code)
+}
+
+func responseHeader(resp *network.Response) http.Header {
+	h := http.Header{}
+	for k, v := range resp.Headers {
+		// the browser joins the values of the repeated header with newlines
+		for _, s := range strings.Split(fmt.Sprint(v), "\n") {
+			h.Add(k, s)
+		}
+	}
+
+	return h
+}
@@ -0,0 +1,331 @@
+package dl
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/pkg/errors"
+	"golang.org/x/net/publicsuffix"
+)
+
+type (
+	// Credentials are the cookies and the headers the requests to the particular domains are authenticated with.
+	// Every one of them is sent only to the domain it's configured for.
+	Credentials struct {
+		cookies []Cookie
+		headers []DomainHeader
+	}
+
+	// Cookie is the entry of the Netscape cookies.txt file.
+	Cookie struct {
+		// Domain is the host the cookie is sent to, along with its subdomains if IncludeSubdomains is set
+		Domain            string
+		IncludeSubdomains bool
+		Path              string
+		Secure, HttpOnly  bool
+		// Expires is zero for the session cookies
+		Expires     time.Time
+		Name, Value string
+	}
+
+	// DomainHeader is the header sent to the domain. The domain starting with a dot covers its subdomains as well,
+	// e.g. ".example.com" is both example.com and wiki.example.com, while "example.com" is that host only.
+	// The header is sent over HTTPS only, the same as the secure cookie, unless it's Insecure.
+	DomainHeader struct {
+		Domain, Name, Value string
+		Insecure            bool
+	}
+
+	// credentialsTransport adds the headers of the domain to every request, the redirected ones included.
+	credentialsTransport struct {
+		base  http.RoundTripper
+		creds *Credentials
+	}
+)
+
+const (
+	_httpOnlyPrefix = "#HttpOnly_"
+	_cookieFields   = 7
+	// _insecurePrefix is the prefix of the domain which headers are sent over plain HTTP as well
+	_insecurePrefix = "http://"
+)
+
+// LoadCredentials reads the cookies from the Netscape cookies.txt file and the headers from the file with
+// a "<domain> <Name>: <value>" line per header. Either of the paths could be empty.
+func LoadCredentials(cookiesPath, headersPath string) (*Credentials, error) {
+	var (
+		cookies []Cookie
+		headers []DomainHeader
+	)
+
+	if cookiesPath != "" {
+		f, err := os.Open(cookiesPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't open cookies file")
+		}
+		defer f.Close()
+
+		cookies, err = ParseCookies(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse cookies file %s", cookiesPath)
+		}
+	}
+
+	if headersPath != "" {
+		f, err := os.Open(headersPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't open headers file")
+		}
+		defer f.Close()
+
+		headers, err = ParseHeaders(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse headers file %s", headersPath)
+		}
+	}
+
+	return NewCredentials(cookies, headers)
+}
+
+func NewCredentials(cookies []Cookie, headers []DomainHeader) (*Credentials, error) {
+	c := &Credentials{cookies: cookies, headers: headers}
+	// the jar is built per request, so it's checked it could be built at all
+	_, err := c.newJar()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// newJar returns the jar holding the configured cookies, which are not expired yet.
+func (c *Credentials) newJar() (*cookiejar.Jar, error) {
+	// the public suffix list keeps a cookie of e.g. ".co.uk" from being sent to every site under it
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, ck := range c.cookies {
+		if !ck.Expires.IsZero() && ck.Expires.Before(now) {
+			continue
+		}
+
+		hc := &http.Cookie{
+			Name:     ck.Name,
+			Value:    ck.Value,
+			Path:     ck.Path,
+			Secure:   ck.Secure,
+			HttpOnly: ck.HttpOnly,
+			Expires:  ck.Expires,
+		}
+		// the cookie without the domain is sent to the host it's set by only
+		if ck.IncludeSubdomains {
+			hc.Domain = ck.Domain
+		}
+		jar.SetCookies(ck.url(), []*http.Cookie{hc})
+	}
+
+	return jar, nil
+}
+
+// ParseCookies parses the cookies in the Netscape format, the one cookies.txt browser extensions and curl export.
+func ParseCookies(r io.Reader) ([]Cookie, error) {
+	var cookies []Cookie
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+
+		var httpOnly bool
+		if strings.HasPrefix(line, _httpOnlyPrefix) {
+			line = strings.TrimPrefix(line, _httpOnlyPrefix)
+			httpOnly = true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fs := strings.Split(line, "\t")
+		if len(fs) != _cookieFields {
+			return nil, errors.Errorf("line %d: expected %d tab separated fields, got %d", n, _cookieFields, len(fs))
+		}
+
+		expires, err := strconv.ParseInt(fs[4], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("line %d: invalid expiration time %q", n, fs[4])
+		}
+
+		c := Cookie{
+			Domain:            strings.ToLower(strings.TrimPrefix(fs[0], ".")),
+			IncludeSubdomains: strings.EqualFold(fs[1], "TRUE"),
+			Path:              fs[2],
+			Secure:            strings.EqualFold(fs[3], "TRUE"),
+			HttpOnly:          httpOnly,
+			Name:              fs[5],
+			Value:             fs[6],
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		if c.Domain == "" {
+			return nil, errors.Errorf("line %d: domain is empty", n)
+		}
+		cookies = append(cookies, c)
+	}
+
+	return cookies, s.Err()
+}
+
+// ParseHeaders parses the headers, one "<domain> <Name>: <value>" per line. The domain prefixed with "http://"
+// gets the header over plain HTTP as well. Empty lines and the ones starting with "#" are skipped.
+func ParseHeaders(r io.Reader) ([]DomainHeader, error) {
+	var headers []DomainHeader
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fs := strings.SplitN(line, " ", 2)
+		if len(fs) != 2 {
+			return nil, errors.Errorf("line %d: expected \"<domain> <Name>: <value>\"", n)
+		}
+		i := strings.Index(fs[1], ":")
+		if i <= 0 {
+			return nil, errors.Errorf("line %d: expected \"<domain> <Name>: <value>\"", n)
+		}
+
+		domain := strings.ToLower(fs[0])
+		headers = append(headers, DomainHeader{
+			Domain:   strings.TrimPrefix(domain, _insecurePrefix),
+			Name:     http.CanonicalHeaderKey(strings.TrimSpace(fs[1][:i])),
+			Value:    strings.TrimSpace(fs[1][i+1:]),
+			Insecure: strings.HasPrefix(domain, _insecurePrefix),
+		})
+	}
+
+	return headers, s.Err()
+}
+
+// Jar returns the new cookie jar holding the cookies, it decides which of them are sent along with the request.
+// The cookies set by the sites go to the jar of the request, so they last for its redirect chain only and never
+// replace the configured ones.
+func (c *Credentials) Jar() http.CookieJar {
+	jar, err := c.newJar()
+	if err != nil {
+		// NewCredentials has built the jar already, so it's never the case
+		return nil
+	}
+
+	return jar
+}
+
+// Transport wraps the transport, so the headers are added to the requests to their domains.
+func (c *Credentials) Transport(base http.RoundTripper) http.RoundTripper {
+	if len(c.headers) == 0 {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &credentialsTransport{base: base, creds: c}
+}
+
+// Headers returns the headers configured for the host of the URL, the ones which aren't Insecure are returned
+// for the HTTPS URL only.
+func (c *Credentials) Headers(u *url.URL) http.Header {
+	h := http.Header{}
+	host := strings.ToLower(u.Hostname())
+	secure := strings.EqualFold(u.Scheme, "https")
+	for _, dh := range c.headers {
+		if (secure || dh.Insecure) && matchDomain(dh.Domain, host) {
+			h.Set(dh.Name, dh.Value)
+		}
+	}
+
+	return h
+}
+
+// ChromeCookies returns the cookies in the form the browser takes them, it does the scoping by itself.
+func (c *Credentials) ChromeCookies() []*network.CookieParam {
+	now := time.Now()
+
+	var res []*network.CookieParam
+	for _, ck := range c.cookies {
+		if !ck.Expires.IsZero() && ck.Expires.Before(now) {
+			continue
+		}
+
+		p := &network.CookieParam{
+			Name:     ck.Name,
+			Value:    ck.Value,
+			Path:     ck.Path,
+			Secure:   ck.Secure,
+			HTTPOnly: ck.HttpOnly,
+		}
+		if ck.IncludeSubdomains {
+			p.Domain = "." + ck.Domain
+		} else {
+			// the cookie set by the URL, not for the domain, is the host-only one
+			p.URL = ck.url().String()
+		}
+		if !ck.Expires.IsZero() {
+			t := cdp.TimeSinceEpoch(ck.Expires)
+			p.Expires = &t
+		}
+		res = append(res, p)
+	}
+
+	return res
+}
+
+func (t *credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := t.creds.Headers(req.URL)
+	if len(h) == 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	// the request must not be modified by the transport
+	r := req.Clone(req.Context())
+	for k, v := range h {
+		r.Header[k] = v
+	}
+
+	return t.base.RoundTrip(r)
+}
+
+// url returns the URL the cookie is set by.
+func (c Cookie) url() *url.URL {
+	scheme := "http"
+	if c.Secure {
+		scheme = "https"
+	}
+
+	return &url.URL{Scheme: scheme, Host: c.Domain, Path: c.Path}
+}
+
+// matchDomain reports whether the host is the domain, or its subdomain if the domain starts with a dot.
+func matchDomain(domain, host string) bool {
+	if !strings.HasPrefix(domain, ".") {
+		return host == domain
+	}
+
+	return host == domain[1:] || strings.HasSuffix(host, domain)
+}
@@ -1,5 +1,10 @@
 package dl
 
+import (
+	"context"
+	"strings"
+)
+
 type Option func(*config)
 
 func OptUserAgent(ua string) Option {
@@ -11,6 +16,89 @@ func OptUserAgent(ua string) Option {
 	}
 }
 
+// OptUserAgentSource makes the loader to pick the User-Agent from the source for every fetch.
+func OptUserAgentSource(src UserAgentSource) Option {
+	return func(c *config) {
+		c.UserAgents = src
+	}
+}
+
+// OptProductToken adds the product token to the User-Agent of the loader, e.g. the one robots.txt rules are matched
+// by, so the hosts could tell whose rules the requests follow.
+func OptProductToken(token string) Option {
+	return func(c *config) {
+		c.ProductToken = token
+	}
+}
+
+// OptGuard makes the loader to ask the guard before every request to the URL, every attempt made according to
+// the RetryPolicy included. The request isn't made if the guard returns an error.
+func OptGuard(g Guard) Option {
+	return func(c *config) {
+		c.Guard = g
+	}
+}
+
+// OptRetryPolicy sets the policy failed fetches are retried by, DefaultRetryPolicy is used otherwise.
+func OptRetryPolicy(p RetryPolicy) Option {
+	return func(c *config) {
+		c.Retry = p
+	}
+}
+
+// OptCredentials makes the loader to authenticate the requests to the domains the credentials are configured for.
+func OptCredentials(c *Credentials) Option {
+	return func(cfg *config) {
+		cfg.Credentials = c
+	}
+}
+
+// OptProfiles makes the loader to reach the hosts according to their network profiles.
+func OptProfiles(p *Profiles) Option {
+	return func(c *config) {
+		c.Profiles = p
+	}
+}
+
 type config struct {
-	UserAgent string
+	UserAgent    string
+	UserAgents   UserAgentSource
+	ProductToken string
+	Guard        Guard
+	Retry        RetryPolicy
+	Credentials  *Credentials
+	Profiles     *Profiles
+	Limits       Limits
+}
+
+func (c config) userAgent() (string, error) {
+	if c.UserAgents == nil {
+		return c.withToken(c.UserAgent), nil
+	}
+
+	ua, err := c.UserAgents.Get()
+	if err != nil {
+		return "", err
+	}
+	if ua == "" {
+		ua = c.UserAgent
+	}
+
+	return c.withToken(ua), nil
+}
+
+func (c config) withToken(ua string) string {
+	if c.ProductToken == "" || strings.Contains(ua, c.ProductToken) {
+		return ua
+	}
+
+	return ua + " " + c.ProductToken
+}
+
+func (c config) guard(ctx context.Context, url string) error {
+	if c.Guard == nil {
+		return nil
+	}
+
+	return c.Guard(ctx, url)
 }
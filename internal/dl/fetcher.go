@@ -0,0 +1,79 @@
+package dl
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+type (
+	// Fetcher retrieves the content behind the URL. Implementations are expected
+	// to return a non-nil Result whenever the remote side responded, even with a
+	// non-200 status, and an error only when no response could be obtained.
+	Fetcher interface {
+		Fetch(ctx context.Context, url string) (*Result, error)
+	}
+
+	// FetcherFunc adapts an ordinary function to the Fetcher interface.
+	FetcherFunc func(ctx context.Context, url string) (*Result, error)
+
+	Result struct {
+		// URL is the requested URL.
+		URL string
+		// FinalURL is the URL the content was eventually retrieved from, after all redirects.
+		FinalURL string
+		// Redirects are the URLs redirected to the next one on the way to FinalURL, in order.
+		Redirects []string
+		// Loader is the name of the fetcher produced the result.
+		Loader     string
+		StatusCode int
+		Header     http.Header
+		Body       []byte
+		// TooLarge tells that the body exceeds the limit, so it's not downloaded and Body is empty
+		TooLarge bool
+		// Size is the size of the body, for the one which is too large it's the declared size, -1 if it's not known
+		Size int64
+		// Steps holds the trace of the fetchers tried by a Chain, in order.
+		Steps []Step
+		// Attempts holds the trace of the tries of the fetcher made according to its RetryPolicy, in order.
+		Attempts []Attempt
+	}
+
+	// Step is a single fetcher invocation made by a Chain.
+	Step struct {
+		Loader     string
+		StatusCode int
+		Err        error
+		Attempts   []Attempt
+	}
+
+	// Guard tells if the request to the URL could be made, it could make the request to wait as well.
+	Guard func(ctx context.Context, url string) error
+
+	// UserAgentSource provides User-Agent strings for outgoing requests.
+	UserAgentSource interface {
+		Get() (string, error)
+	}
+)
+
+func (f FetcherFunc) Fetch(ctx context.Context, url string) (*Result, error) {
+	return f(ctx, url)
+}
+
+// ContentType returns the media type of the response, sniffing it if the header doesn't tell. The images, favicons
+// especially, are often served as application/octet-stream or text/plain.
+func (r *Result) ContentType() string {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt == "" || mt == "application/octet-stream" || mt == "text/plain" {
+		sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(r.Body))
+		if sniffed != "application/octet-stream" && sniffed != "text/plain" || mt == "" {
+			mt = sniffed
+		}
+	}
+	if mt == "text/xml" && strings.HasSuffix(strings.ToLower(r.URL), ".svg") {
+		mt = "image/svg+xml"
+	}
+
+	return mt
+}
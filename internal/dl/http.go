@@ -3,38 +3,97 @@ package dl
 import (
 	"context"
 	"net/http"
+
+	"github.com/pkg/errors"
 )
 
 type (
 	HttpInstance struct {
 		cfg config
+		// transport is shared by the requests, so the connections are reused
+		transport http.RoundTripper
 	}
 )
 
+const (
+	HttpLoaderName = "http"
+
+	// the same as the default policy of http.Client
+	maxRedirects = 10
+)
+
 func NewHttpLoader(options ...Option) *HttpInstance {
 	cfg := config{
 		UserAgent: DefaultUA,
+		Retry:     DefaultRetryPolicy,
+		Limits:    DefaultLimits,
 	}
 	for _, opt := range options {
 		opt(&cfg)
 	}
 
-	return &HttpInstance{cfg: cfg}
+	base := cfg.Limits.transport()
+	var transport http.RoundTripper = base
+	if cfg.Profiles != nil {
+		transport = cfg.Profiles.Transport(base)
+	}
+	if cfg.Credentials != nil {
+		transport = cfg.Credentials.Transport(transport)
+	}
+
+	return &HttpInstance{cfg: cfg, transport: transport}
 }
 
 func (hi *HttpInstance) Get(ctx context.Context, url string, ua string) (*http.Response, error) {
-	client := &http.Client{}
+	return hi.get(ctx, url, ua, nil)
+}
+
+// get does the request, the URLs which were redirected from are appended to redirects, if it's not nil.
+func (hi *HttpInstance) get(ctx context.Context, url string, ua string, redirects *[]string) (*http.Response, error) {
+	// the client is cheap, it's the transport which keeps the connections
+	client := &http.Client{
+		Transport: hi.transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if redirects != nil {
+				*redirects = append(*redirects, via[len(via)-1].URL.String())
+			}
+			return nil
+		},
+	}
+	if c := hi.cfg.Credentials; c != nil {
+		// the jar and the transport pick the credentials for every hop of the redirect chain,
+		// so they never follow the request to another domain, the jar is the request's own one
+		client.Jar = c.Jar()
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	// the timeout covers reading the body as well
+	client.Timeout = hi.cfg.Limits.Timeout
+	if p := hi.cfg.Profiles; p != nil && p.Timeout(req.URL.Hostname()) > 0 {
+		client.Timeout = p.Timeout(req.URL.Hostname())
+	}
 
 	req.Header.Set("Referer", "https://www.google.com/")
-	req.Header.Set("Accept-Charset","utf-8")
+	req.Header.Set("Accept-Charset", "utf-8")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.9,ru;q=0.8")
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9")
 	req.Header.Set("User-Agent", ua)
 
+	if v, ok := validatorsFrom(ctx); ok {
+		if v.ETag != "" {
+			req.Header.Set("If-None-Match", v.ETag)
+		}
+		if v.LastModified != "" {
+			req.Header.Set("If-Modified-Since", v.LastModified)
+		}
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -42,3 +101,46 @@ func (hi *HttpInstance) Get(ctx context.Context, url string, ua string) (*http.R
 
 	return resp, nil
 }
+
+// Fetch implements Fetcher. Failed requests are retried according to the RetryPolicy of the loader.
+func (hi *HttpInstance) Fetch(ctx context.Context, url string) (*Result, error) {
+	return hi.cfg.Retry.Do(ctx, func(ctx context.Context) (*Result, error) {
+		err := hi.cfg.guard(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		return hi.fetch(ctx, url)
+	})
+}
+
+func (hi *HttpInstance) fetch(ctx context.Context, url string) (*Result, error) {
+	ua, err := hi.cfg.userAgent()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get User Agent")
+	}
+
+	var redirects []string
+	r, err := hi.get(ctx, url, ua, &redirects)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	body, size, tooLarge, err := hi.cfg.Limits.readBody(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read response body for HREF: %s", url)
+	}
+
+	return &Result{
+		URL:        url,
+		FinalURL:   r.Request.URL.String(),
+		Redirects:  redirects,
+		Loader:     HttpLoaderName,
+		StatusCode: r.StatusCode,
+		Header:     r.Header,
+		Body:       body,
+		TooLarge:   tooLarge,
+		Size:       size,
+	}, nil
+}
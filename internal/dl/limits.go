@@ -0,0 +1,81 @@
+package dl
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+type (
+	// Limits bound the resources a single fetch could take, zero means no bound.
+	Limits struct {
+		// ConnectTimeout bounds establishing the connection along with the TLS handshake
+		ConnectTimeout time.Duration
+		// HeaderTimeout bounds waiting for the response once the request is sent
+		HeaderTimeout time.Duration
+		// Timeout bounds the whole fetch including reading the body
+		Timeout time.Duration
+		// MaxBodySize is the size of the body the content is not downloaded beyond
+		MaxBodySize int64
+	}
+)
+
+var (
+	// DefaultLimits are generous enough for any page, while keeping a multi-GB download
+	// or a never-ending stream from holding the worker
+	DefaultLimits = Limits{
+		ConnectTimeout: time.Second * 10,
+		HeaderTimeout:  time.Second * 30,
+		Timeout:        time.Minute * 2,
+		MaxBodySize:    50 << 20,
+	}
+)
+
+// OptLimits sets the limits of the fetches, DefaultLimits are used otherwise.
+func OptLimits(l Limits) Option {
+	return func(c *config) {
+		c.Limits = l
+	}
+}
+
+// transport returns the transport with the connect and header timeouts set.
+func (l Limits) transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if l.ConnectTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   l.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		t.TLSHandshakeTimeout = l.ConnectTimeout
+	}
+	t.ResponseHeaderTimeout = l.HeaderTimeout
+
+	return t
+}
+
+// readBody reads the body unless it's larger than the limit. The declared Content-Length is checked first,
+// so the oversized body is not downloaded at all, otherwise the read is aborted once it's beyond the limit.
+// The oversized body is reported with nil content, along with its declared size, which is -1 if it's not known.
+func (l Limits) readBody(r *http.Response) (body []byte, size int64, tooLarge bool, err error) {
+	if l.MaxBodySize <= 0 {
+		body, err = ioutil.ReadAll(r.Body)
+		return body, int64(len(body)), false, err
+	}
+
+	if r.ContentLength > l.MaxBodySize {
+		return nil, r.ContentLength, true, nil
+	}
+
+	body, err = ioutil.ReadAll(io.LimitReader(r.Body, l.MaxBodySize+1))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if int64(len(body)) > l.MaxBodySize {
+		return nil, r.ContentLength, true, nil
+	}
+
+	return body, int64(len(body)), false, nil
+}
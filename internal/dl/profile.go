@@ -0,0 +1,335 @@
+package dl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Profile is the network setup of the hosts matching its patterns.
+	Profile struct {
+		Name string `json:"name"`
+		// Hosts are either the host names, e.g. "wiki.example.com", or the patterns of the subdomains,
+		// e.g. "*.corp.example.com". "*" matches every host.
+		Hosts []string `json:"hosts"`
+		// Proxy is the URL of the HTTP, HTTPS or SOCKS5 proxy, e.g. "socks5://127.0.0.1:1080".
+		// The hosts are reached directly if it's empty.
+		Proxy string `json:"proxy,omitempty"`
+		// CAFile is the PEM bundle of the certificate authorities trusted along with the system ones
+		CAFile string `json:"ca_file,omitempty"`
+		// CertFile and KeyFile are the PEM client certificate and its key, for the hosts requiring mutual TLS
+		CertFile string `json:"cert_file,omitempty"`
+		KeyFile  string `json:"key_file,omitempty"`
+		// ConnectTimeout bounds establishing the connection, HeaderTimeout bounds waiting for the response
+		// once the request is sent and Timeout bounds the whole request including reading the body
+		ConnectTimeout Duration `json:"connect_timeout,omitempty"`
+		HeaderTimeout  Duration `json:"header_timeout,omitempty"`
+		Timeout        Duration `json:"timeout,omitempty"`
+		// Headers are sent with every request to the hosts
+		Headers map[string]string `json:"headers,omitempty"`
+	}
+
+	// Profiles are the network setups of the hosts, the first profile matching the host is the one used.
+	Profiles struct {
+		profiles []Profile
+		proxies  []*url.URL
+		tls      []*tls.Config
+	}
+
+	// profileRouter routes the requests through the transport of the profile matching the host,
+	// the requests to the rest of the hosts go through the base one.
+	profileRouter struct {
+		ps         *Profiles
+		base       http.RoundTripper
+		transports []*http.Transport
+	}
+
+	// Duration is time.Duration which is written as "30s" in the config.
+	Duration time.Duration
+
+	profilesConfig struct {
+		Profiles []Profile `json:"profiles"`
+	}
+)
+
+const (
+	_anyHost       = "*"
+	_subdomainMark = "*."
+)
+
+// LoadProfiles reads the profiles from the JSON file, {"profiles": [...]}.
+func LoadProfiles(path string) (*Profiles, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read network profiles")
+	}
+
+	var cfg profilesConfig
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	err = d.Decode(&cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse network profiles %s", path)
+	}
+
+	return NewProfiles(cfg.Profiles)
+}
+
+// NewProfiles validates the profiles, loading their certificates once.
+func NewProfiles(profiles []Profile) (*Profiles, error) {
+	ps := &Profiles{profiles: profiles}
+
+	for i, p := range profiles {
+		if len(p.Hosts) == 0 {
+			return nil, errors.Errorf("network profile %s has no hosts", p.label(i))
+		}
+
+		proxy, err := p.proxyURL()
+		if err != nil {
+			return nil, errors.Wrapf(err, "network profile %s", p.label(i))
+		}
+		tc, err := p.tlsConfig()
+		if err != nil {
+			return nil, errors.Wrapf(err, "network profile %s", p.label(i))
+		}
+		ps.proxies = append(ps.proxies, proxy)
+		ps.tls = append(ps.tls, tc)
+	}
+
+	return ps, nil
+}
+
+// Transport returns the transport routing the requests according to the profiles. The transports of the profiles
+// are built out of the base one, so the settings which aren't in the profile stay the same.
+// The profile is picked for every request, so the redirect to another host goes through the profile of that host.
+func (ps *Profiles) Transport(base *http.Transport) http.RoundTripper {
+	r := &profileRouter{ps: ps, base: base}
+	for i, p := range ps.profiles {
+		r.transports = append(r.transports, p.transport(base, ps.proxies[i], ps.tls[i]))
+	}
+
+	return r
+}
+
+// Match returns the first profile matching the host, nil if there is none.
+func (ps *Profiles) Match(host string) *Profile {
+	if i := ps.match(host); i >= 0 {
+		return &ps.profiles[i]
+	}
+
+	return nil
+}
+
+// Timeout returns the time limit of the whole request to the host, zero if there is none.
+func (ps *Profiles) Timeout(host string) time.Duration {
+	if p := ps.Match(host); p != nil {
+		return time.Duration(p.Timeout)
+	}
+
+	return 0
+}
+
+func (r *profileRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := r.ps.match(req.URL.Hostname())
+	if i < 0 {
+		return r.base.RoundTrip(req)
+	}
+
+	if h := r.ps.profiles[i].Headers; len(h) > 0 {
+		req = req.Clone(req.Context())
+		for k, v := range h {
+			req.Header.Set(k, v)
+		}
+	}
+
+	return r.transports[i].RoundTrip(req)
+}
+
+// PAC returns the data URL of the proxy auto-config script routing the hosts through the proxies of their profiles,
+// it's the only way to have the proxy per host in the browser. Empty string is returned if none of the profiles
+// has a proxy.
+func (ps *Profiles) PAC() string {
+	var (
+		script   strings.Builder
+		proxying bool
+	)
+
+	script.WriteString("function FindProxyForURL(url, host) {\n")
+	for _, p := range ps.profiles {
+		route := "DIRECT"
+		if p.Proxy != "" {
+			u, err := url.Parse(p.Proxy)
+			if err != nil {
+				continue
+			}
+			proxying = true
+
+			switch u.Scheme {
+			case "socks5":
+				route = "SOCKS5 " + u.Host
+			case "https":
+				route = "HTTPS " + u.Host
+			default:
+				route = "PROXY " + u.Host
+			}
+		}
+
+		for _, h := range p.Hosts {
+			pattern, _ := json.Marshal(strings.ToLower(h))
+			fmt.Fprintf(&script, "  if (shExpMatch(host, %s)) return %q;\n", pattern, route)
+		}
+	}
+	script.WriteString("  return \"DIRECT\";\n}\n")
+
+	if !proxying {
+		return ""
+	}
+
+	return "data:application/x-javascript-config;base64," + base64.StdEncoding.EncodeToString([]byte(script.String()))
+}
+
+func (ps *Profiles) match(host string) int {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for i, p := range ps.profiles {
+		for _, h := range p.Hosts {
+			if matchHost(strings.ToLower(h), host) {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// transport builds the transport of the profile out of the base one.
+func (p Profile) transport(base *http.Transport, proxy *url.URL, tc *tls.Config) *http.Transport {
+	t := base.Clone()
+
+	// the proxy of the environment is not used for the hosts of the profile, they are either proxied or direct
+	t.Proxy = nil
+	if proxy != nil {
+		t.Proxy = http.ProxyURL(proxy)
+	}
+
+	if p.ConnectTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   time.Duration(p.ConnectTimeout),
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		t.TLSHandshakeTimeout = time.Duration(p.ConnectTimeout)
+	}
+	if p.HeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = time.Duration(p.HeaderTimeout)
+	}
+	if tc != nil {
+		t.TLSClientConfig = tc
+	}
+
+	return t
+}
+
+func (p Profile) proxyURL() (*url.URL, error) {
+	if p.Proxy == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(p.Proxy)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid proxy URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	return u, nil
+}
+
+// tlsConfig loads the CA bundle and the client certificate, nil is returned if the profile has neither.
+func (p Profile) tlsConfig() (*tls.Config, error) {
+	if p.CAFile == "" && p.CertFile == "" {
+		return nil, nil
+	}
+
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if p.CAFile != "" {
+		pem, err := ioutil.ReadFile(p.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't read CA file")
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in CA file %s", p.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	if p.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't load client certificate")
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
+
+func (p Profile) label(i int) string {
+	if p.Name != "" {
+		return p.Name
+	}
+
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// matchHost reports whether the host matches the pattern, "*.example.com" matches the subdomains of example.com,
+// but not example.com itself.
+func matchHost(pattern, host string) bool {
+	switch {
+	case pattern == _anyHost:
+		return true
+	case strings.HasPrefix(pattern, _subdomainMark):
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return host == pattern
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return errors.Errorf("duration must be a string like \"30s\", got %s", b)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
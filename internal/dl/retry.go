@@ -0,0 +1,272 @@
+package dl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// RetryPolicy decides whether a failed attempt to fetch the page is repeated and how long to wait before that.
+	RetryPolicy struct {
+		// MaxAttempts is the number of attempts including the first one, 1 disables retries
+		MaxAttempts int
+		// Statuses are the response codes worth another attempt
+		Statuses []int
+		// Retryable decides whether the error is worth another attempt, IsTransient is used if it's nil
+		Retryable func(err error) bool
+		// BaseDelay is the delay before the second attempt, it doubles with every next one up to MaxDelay.
+		// The actual delay is randomized, so the clients which failed together don't retry together.
+		BaseDelay, MaxDelay time.Duration
+		// Deadline bounds the total time of the attempts along with the delays between them, zero means no bound
+		Deadline time.Duration
+	}
+
+	// Attempt is a single try to fetch the page made according to a RetryPolicy.
+	Attempt struct {
+		StatusCode int
+		Err        error
+		// Took is the time the attempt took
+		Took time.Duration
+		// Wait is the delay before the next attempt, zero for the last one
+		Wait time.Duration
+	}
+
+	// RetryError is returned when every attempt failed, it keeps the trace of them.
+	RetryError struct {
+		Attempts []Attempt
+		Err      error
+	}
+)
+
+const (
+	_statusSeparator = ","
+)
+
+var (
+	// DefaultRetryPolicy retries throttled and temporarily unavailable responses along with transient network errors.
+	// 403 is there as that's what the bot protection answers with before it lets the client in.
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts: 3,
+		Statuses: []int{
+			http.StatusForbidden,
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		BaseDelay: time.Second * 2,
+		MaxDelay:  time.Second * 30,
+		Deadline:  time.Minute,
+	}
+)
+
+// Do calls fetch until it succeeds, the outcome is not worth another attempt, the attempts are exhausted,
+// the next one wouldn't fit into the deadline or the context is done. A "Retry-After" header of the response
+// is preferred over the policy's own delay. The attempts made are recorded into the result, or into
+// the RetryError if there is no result.
+func (p RetryPolicy) Do(ctx context.Context, fetch func(ctx context.Context) (*Result, error)) (*Result, error) {
+	var (
+		start    = time.Now()
+		attempts []Attempt
+	)
+
+	for i := 1; ; i++ {
+		began := time.Now()
+		res, err := fetch(ctx)
+
+		a := Attempt{Err: err, Took: time.Since(began)}
+		if res != nil {
+			a.StatusCode = res.StatusCode
+		}
+
+		wait, retry := p.next(i, res, err)
+		if retry && ctx.Err() == nil && (p.Deadline <= 0 || time.Since(start)+wait < p.Deadline) {
+			a.Wait = wait
+		} else {
+			retry = false
+		}
+		attempts = append(attempts, a)
+
+		if retry && sleep(ctx, wait) != nil {
+			// there was no next attempt
+			attempts[len(attempts)-1].Wait = 0
+			retry = false
+		}
+		if !retry {
+			if res != nil {
+				res.Attempts = attempts
+			}
+			if err != nil && len(attempts) > 1 {
+				err = &RetryError{Attempts: attempts, Err: err}
+			}
+
+			return res, err
+		}
+	}
+}
+
+// next tells whether the outcome of the attempt is worth another one and how long to wait before it.
+func (p RetryPolicy) next(attempt int, res *Result, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	if err != nil {
+		retryable := p.Retryable
+		if retryable == nil {
+			retryable = IsTransient
+		}
+
+		return p.backoff(attempt), retryable(err)
+	}
+
+	if !p.retryableStatus(res.StatusCode) {
+		return 0, false
+	}
+	if d, ok := retryAfter(res.Header, time.Now()); ok {
+		return d, true
+	}
+
+	return p.backoff(attempt), true
+}
+
+func (p RetryPolicy) retryableStatus(code int) bool {
+	for _, s := range p.Statuses {
+		if s == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the delay after the attempt, picked randomly from the upper half of the exponential one.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// IsTransient reports whether the network error is likely to go away by itself, e.g. a reset connection or a timeout.
+// The errors of the context are never transient.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var (
+		dnsErr *net.DNSError
+		netErr net.Error
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+
+	return false
+}
+
+// ParseStatuses parses the comma separated list of status codes.
+func ParseStatuses(spec string) ([]int, error) {
+	var codes []int
+
+	for _, s := range strings.Split(spec, _statusSeparator) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return nil, errors.Errorf("invalid status code %q", s)
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// retryAfter parses the "Retry-After" header, which holds either the number of seconds or the date.
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+
+	return 0, true
+}
+
+// sleep waits for the duration, unless the context is done before that.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s, gave up after %d attempts", e.Err, len(e.Attempts))
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+func (a Attempt) String() string {
+	outcome := strconv.Itoa(a.StatusCode)
+	if a.Err != nil {
+		outcome = a.Err.Error()
+	}
+
+	s := fmt.Sprintf("%s in %s", outcome, a.Took.Round(time.Millisecond))
+	if a.Wait > 0 {
+		s += fmt.Sprintf(", retried in %s", a.Wait.Round(time.Millisecond))
+	}
+
+	return s
+}
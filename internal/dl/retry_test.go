@@ -0,0 +1,137 @@
+package dl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{header: "", ok: false},
+		{header: "120", want: 2 * time.Minute, ok: true},
+		{header: " 0 ", want: 0, ok: true},
+		{header: "-5", ok: false},
+		{header: now.Add(30 * time.Second).Format(http.TimeFormat), want: 30 * time.Second, ok: true},
+		// the date in the past means retrying right away
+		{header: now.Add(-time.Hour).Format(http.TimeFormat), want: 0, ok: true},
+		{header: "tomorrow", ok: false},
+	}
+
+	for _, c := range cases {
+		h := http.Header{}
+		if c.header != "" {
+			h.Set("Retry-After", c.header)
+		}
+
+		got, ok := retryAfter(h, now)
+		if ok != c.ok || got != c.want {
+			t.Errorf("retryAfter(%q) = %s, %t, want %s, %t", c.header, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := RetryPolicy{
+		MaxAttempts: 3,
+		Statuses:    []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		// the backoff is long enough for the test to hang if Retry-After wasn't preferred over it
+		BaseDelay: time.Hour,
+		MaxDelay:  time.Hour,
+	}
+
+	t.Run("retry after is preferred over backoff", func(t *testing.T) {
+		var calls int
+		res, err := p.Do(context.Background(), func(ctx context.Context) (*Result, error) {
+			calls++
+			if calls == 1 {
+				h := http.Header{}
+				h.Set("Retry-After", "0")
+				return &Result{StatusCode: http.StatusTooManyRequests, Header: h}, nil
+			}
+			return &Result{StatusCode: http.StatusOK}, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if res.StatusCode != http.StatusOK || calls != 2 {
+			t.Fatalf("got %d after %d calls, want 200 after 2", res.StatusCode, calls)
+		}
+		if len(res.Attempts) != 2 || res.Attempts[0].StatusCode != http.StatusTooManyRequests || res.Attempts[0].Wait != 0 {
+			t.Errorf("unexpected attempts %v", res.Attempts)
+		}
+	})
+
+	t.Run("retry after beyond deadline gives up", func(t *testing.T) {
+		p := p
+		p.Deadline = time.Minute
+
+		var calls int
+		res, err := p.Do(context.Background(), func(ctx context.Context) (*Result, error) {
+			calls++
+			h := http.Header{}
+			h.Set("Retry-After", "3600")
+			return &Result{StatusCode: http.StatusServiceUnavailable, Header: h}, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if res.StatusCode != http.StatusServiceUnavailable || calls != 1 {
+			t.Errorf("got %d after %d calls, want 503 after 1", res.StatusCode, calls)
+		}
+	})
+
+	t.Run("status not in policy isn't retried", func(t *testing.T) {
+		var calls int
+		res, _ := p.Do(context.Background(), func(ctx context.Context) (*Result, error) {
+			calls++
+			return &Result{StatusCode: http.StatusNotFound}, nil
+		})
+		if res.StatusCode != http.StatusNotFound || calls != 1 {
+			t.Errorf("got %d after %d calls, want 404 after 1", res.StatusCode, calls)
+		}
+	})
+
+	t.Run("attempts are exhausted", func(t *testing.T) {
+		p := p
+		p.BaseDelay, p.MaxDelay = time.Millisecond, time.Millisecond
+		errReset := errors.New("reset")
+		p.Retryable = func(err error) bool { return errors.Is(err, errReset) }
+
+		var calls int
+		_, err := p.Do(context.Background(), func(ctx context.Context) (*Result, error) {
+			calls++
+			return nil, errReset
+		})
+
+		var retryErr *RetryError
+		if !errors.As(err, &retryErr) || len(retryErr.Attempts) != 3 || calls != 3 {
+			t.Fatalf("got %v after %d calls, want RetryError after 3", err, calls)
+		}
+		if !errors.Is(err, errReset) {
+			t.Errorf("RetryError doesn't unwrap to the cause: %v", err)
+		}
+	})
+
+	t.Run("cancelled context stops retries", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var calls int
+		res, _ := p.Do(ctx, func(ctx context.Context) (*Result, error) {
+			calls++
+			cancel()
+			return &Result{StatusCode: http.StatusServiceUnavailable}, nil
+		})
+		if calls != 1 || len(res.Attempts) != 1 || res.Attempts[0].Wait != 0 {
+			t.Errorf("got %d calls and attempts %v, want a single one", calls, res.Attempts)
+		}
+	})
+}
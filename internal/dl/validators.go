@@ -0,0 +1,42 @@
+package dl
+
+import (
+	"context"
+	"net/http"
+)
+
+// Validators are the cache validators of a previously fetched response.
+// When put into the context with WithValidators, the HTTP loader makes a conditional request.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+type validatorsKey struct{}
+
+func WithValidators(ctx context.Context, v Validators) context.Context {
+	return context.WithValue(ctx, validatorsKey{}, v)
+}
+
+func validatorsFrom(ctx context.Context) (Validators, bool) {
+	v, ok := ctx.Value(validatorsKey{}).(Validators)
+	return v, ok
+}
+
+// Validators returns the cache validators of the response.
+func (r *Result) Validators() Validators {
+	if r.Header == nil {
+		return Validators{}
+	}
+
+	return Validators{
+		ETag:         r.Header.Get("ETag"),
+		LastModified: r.Header.Get("Last-Modified"),
+	}
+}
+
+// NotModified reports whether the response is an answer to the conditional request
+// telling that the content hasn't changed.
+func (r *Result) NotModified() bool {
+	return r.StatusCode == http.StatusNotModified
+}
@@ -0,0 +1,67 @@
+package dump
+
+import (
+	"encoding/json"
+
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/Neurostep/go-nate/internal/warc"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ArchivedLocations returns the WARC records of the archived bookmarks, only of the given ones if there are any.
+func ArchivedLocations(db *badger.DB, hrefs ...string) ([]warc.Location, error) {
+	var locs []warc.Location
+
+	err := db.View(func(txn *badger.Txn) error {
+		if len(hrefs) > 0 {
+			for _, href := range hrefs {
+				r, err := loadRecord(txn, href)
+				if err != nil {
+					return err
+				}
+				if loc := warcLocation(r); loc != nil {
+					locs = append(locs, *loc)
+				}
+			}
+
+			return nil
+		}
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !keys.IsBookmark(item.Key()) {
+				continue
+			}
+
+			var r Record
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &r)
+			})
+			if err != nil {
+				return err
+			}
+			if loc := warcLocation(r); loc != nil {
+				locs = append(locs, *loc)
+			}
+		}
+
+		return nil
+	})
+
+	return locs, err
+}
+
+// warcLocation returns the WARC record of the bookmark, nil if it isn't archived.
+func warcLocation(r Record) *warc.Location {
+	if r.String("warc_file") == "" {
+		return nil
+	}
+
+	return &warc.Location{
+		File:   r.String("warc_file"),
+		Offset: r.Int("warc_offset"),
+		Length: r.Int("warc_length"),
+	}
+}
@@ -0,0 +1,314 @@
+package dump
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/Neurostep/go-nate/internal/urlnorm"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+type (
+	// RunInfo describes a single Run, so it could be resumed if it's interrupted.
+	RunInfo struct {
+		ID          string `json:"id"`
+		State       string `json:"state"`
+		StartedAt   string `json:"started_at"`
+		FinishedAt  string `json:"finished_at,omitempty"`
+		Force       bool   `json:"force"`
+		Refresh     bool   `json:"refresh"`
+		RetryFailed bool   `json:"retry_failed"`
+		// Total is the number of bookmarks the run has to dump
+		Total int `json:"total"`
+		// Processed and Failed are counted once the run is over
+		Processed int `json:"processed,omitempty"`
+		Failed    int `json:"failed,omitempty"`
+	}
+
+	// checkpoint keeps track of the bookmarks of the run, every one of them is pending
+	// until it's either processed or failed.
+	checkpoint struct {
+		db  *badger.DB
+		run RunInfo
+	}
+
+	checkpointItem struct {
+		Href    string   `json:"url"`
+		Folder  string   `json:"folder,omitempty"`
+		Title   string   `json:"title,omitempty"`
+		Aliases []string `json:"aliases,omitempty"`
+		State   string   `json:"state"`
+	}
+)
+
+const (
+	RunRunning  = "running"
+	RunFinished = "finished"
+
+	itemPending   = "pending"
+	itemProcessed = "processed"
+	itemFailed    = "failed"
+
+	_runNamespace        = "run"
+	_checkpointNamespace = "checkpoint"
+	// run IDs are timestamps, so they are sorted in the order the runs were started
+	_runIDFormat = "20060102T150405.000Z"
+	_keepRuns    = 20
+)
+
+var (
+	ErrNothingToResume = errors.New("there is no interrupted dump to resume")
+)
+
+// startRun creates the new run. Only the latest runs are kept, as watch starts a new one every time
+// the bookmarks change, the older ones are dropped and can't be resumed anymore.
+func startRun(db *badger.DB, opts RunOptions) (*checkpoint, error) {
+	runs, err := listRuns(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(runs)-_keepRuns+1; i++ {
+		cp := &checkpoint{db: db, run: runs[i]}
+		err = cp.dropItems()
+		if err != nil {
+			return nil, err
+		}
+
+		err = db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(keys.Key(_runNamespace, runs[i].ID))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cp := &checkpoint{db: db, run: RunInfo{
+		ID:          time.Now().UTC().Format(_runIDFormat),
+		State:       RunRunning,
+		StartedAt:   time.Now().UTC().Format(time.RFC3339),
+		Force:       opts.Force,
+		Refresh:     opts.Refresh,
+		RetryFailed: opts.RetryFailed,
+	}}
+
+	return cp, cp.saveRun()
+}
+
+// resumeRun returns the latest of the interrupted runs.
+func resumeRun(db *badger.DB) (*checkpoint, error) {
+	runs, err := listRuns(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].State == RunRunning {
+			return &checkpoint{db: db, run: runs[i]}, nil
+		}
+	}
+
+	return nil, ErrNothingToResume
+}
+
+// listRuns returns the known runs, the oldest first.
+func listRuns(db *badger.DB) ([]RunInfo, error) {
+	var runs []RunInfo
+
+	err := db.View(func(txn *badger.Txn) error {
+		prefix := keys.Prefix(_runNamespace)
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var r RunInfo
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &r)
+			})
+			if err != nil {
+				return err
+			}
+			runs = append(runs, r)
+		}
+
+		return nil
+	})
+
+	return runs, err
+}
+
+// options returns the options the run was started with.
+func (c *checkpoint) options() RunOptions {
+	return RunOptions{Force: c.run.Force, Refresh: c.run.Refresh, RetryFailed: c.run.RetryFailed}
+}
+
+// addPending puts the requests to the checkpoint, as the ones to be dumped.
+func (c *checkpoint) addPending(reqs []DumpRequest) error {
+	wb := c.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, r := range reqs {
+		val, err := json.Marshal(&checkpointItem{
+			Href:    r.Href,
+			Folder:  r.Folder,
+			Title:   r.OriginalTitle,
+			Aliases: r.Aliases,
+			State:   itemPending,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = wb.Set(c.itemKey(r.Href), val)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := wb.Flush()
+	if err != nil {
+		return err
+	}
+
+	c.run.Total = len(reqs)
+
+	return c.saveRun()
+}
+
+// pending returns the requests which are not processed yet.
+func (c *checkpoint) pending() ([]DumpRequest, error) {
+	var reqs []DumpRequest
+
+	err := c.db.View(func(txn *badger.Txn) error {
+		return c.iterate(txn, func(_ []byte, it checkpointItem) error {
+			if it.State == itemPending {
+				reqs = append(reqs, DumpRequest{
+					Href:          it.Href,
+					Folder:        it.Folder,
+					OriginalTitle: it.Title,
+					Aliases:       it.Aliases,
+				})
+			}
+
+			return nil
+		})
+	})
+
+	return reqs, err
+}
+
+// mark moves the bookmark out of the pending ones.
+func (c *checkpoint) mark(href string, failed bool) error {
+	return c.db.Update(func(txn *badger.Txn) error {
+		key := c.itemKey(href)
+
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+
+		var it checkpointItem
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &it)
+		})
+		if err != nil {
+			return err
+		}
+
+		it.State = itemProcessed
+		if failed {
+			it.State = itemFailed
+		}
+
+		val, err := json.Marshal(&it)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(key, val)
+	})
+}
+
+// finish drops the bookmarks of the run, which is not going to be resumed anymore.
+func (c *checkpoint) finish() error {
+	err := c.dropItems()
+	if err != nil {
+		return err
+	}
+
+	c.run.State = RunFinished
+	c.run.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+
+	return c.saveRun()
+}
+
+// dropItems removes the bookmarks of the run, counting the processed and failed ones.
+func (c *checkpoint) dropItems() error {
+	var ks [][]byte
+	err := c.db.View(func(txn *badger.Txn) error {
+		return c.iterate(txn, func(k []byte, it checkpointItem) error {
+			switch it.State {
+			case itemProcessed:
+				c.run.Processed++
+			case itemFailed:
+				c.run.Failed++
+			}
+			ks = append(ks, k)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	wb := c.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range ks {
+		err = wb.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return wb.Flush()
+}
+
+func (c *checkpoint) saveRun() error {
+	val, err := json.Marshal(&c.run)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(keys.Key(_runNamespace, c.run.ID), val)
+	})
+}
+
+func (c *checkpoint) iterate(txn *badger.Txn, fn func(k []byte, it checkpointItem) error) error {
+	prefix := keys.Prefix(_checkpointNamespace, c.run.ID)
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		var ci checkpointItem
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &ci)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = fn(it.Item().KeyCopy(nil), ci)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *checkpoint) itemKey(href string) []byte {
+	return keys.Key(_checkpointNamespace, c.run.ID, urlnorm.Key(href))
+}
@@ -0,0 +1,139 @@
+package dump
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+)
+
+func TestRunResume(t *testing.T) {
+	const (
+		ok        = "https://example.com/ok"
+		broken    = "https://example.com/broken"
+		interrupt = "https://example.com/interrupt"
+		later     = "https://example.com/later"
+	)
+
+	var (
+		cancel  context.CancelFunc
+		fetched []string
+	)
+	d := newTestDump(t, dl.FetcherFunc(func(ctx context.Context, url string) (*dl.Result, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fetched = append(fetched, url)
+
+		switch url {
+		case broken:
+			return nil, errors.New("connection refused")
+		case interrupt:
+			if cancel != nil {
+				cancel()
+				return nil, context.Canceled
+			}
+		}
+		return textPage(ctx, url)
+	}), bookmarks{
+		{URI: ok, Folder: "f"},
+		{URI: broken, Folder: "f"},
+		{URI: interrupt, Folder: "f", Title: "Interrupted"},
+		{URI: later, Folder: "f"},
+		// the alias of the bookmark isn't dumped on its own
+		{URI: "http://example.com/later/", Folder: "f"},
+	})
+
+	err := d.Run(context.Background(), RunOptions{Resume: true})
+	if !errors.Is(err, ErrNothingToResume) {
+		t.Fatalf("got %v, want nothing to resume before any run", err)
+	}
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	err = d.Run(ctx, RunOptions{Force: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cp, err := resumeRun(d.db)
+	if err != nil {
+		t.Fatalf("interrupted run can't be resumed: %v", err)
+	}
+	pending, err := cp.pending()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var hrefs []string
+	for _, r := range pending {
+		hrefs = append(hrefs, r.Href)
+		if r.Href == interrupt && r.OriginalTitle != "Interrupted" {
+			t.Errorf("pending bookmark lost its title: %v", r)
+		}
+		if r.Href == later && len(r.Aliases) != 2 {
+			t.Errorf("pending bookmark lost its aliases: %v", r)
+		}
+	}
+	sort.Strings(hrefs)
+	if len(hrefs) != 2 || hrefs[0] != interrupt || hrefs[1] != later {
+		t.Fatalf("got pending %v, want the interrupted bookmark and the one after it", hrefs)
+	}
+
+	// the resumed run dumps only the pending bookmarks, with the options the run was started with
+	cancel = nil
+	fetched = nil
+	err = d.Run(context.Background(), RunOptions{Resume: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(fetched)
+	if len(fetched) != 2 || fetched[0] != interrupt || fetched[1] != later {
+		t.Errorf("resumed run fetched %v, want the pending bookmarks only", fetched)
+	}
+	if !cp.options().Force {
+		t.Errorf("run lost its options: %v", cp.options())
+	}
+
+	runs, err := listRuns(d.db)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("got runs %v, %v, want the single one", runs, err)
+	}
+	if r := runs[0]; r.State != RunFinished || r.Total != 4 || r.Processed != 3 || r.Failed != 1 {
+		t.Errorf("unexpected finished run %+v", r)
+	}
+
+	err = d.Run(context.Background(), RunOptions{Resume: true})
+	if !errors.Is(err, ErrNothingToResume) {
+		t.Errorf("got %v, want nothing to resume after the run is finished", err)
+	}
+}
+
+func TestStartRunKeepsLatest(t *testing.T) {
+	d := newTestDump(t, nil, nil)
+
+	var first string
+	for i := 0; i < _keepRuns+3; i++ {
+		cp, err := startRun(d.db, RunOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if first == "" {
+			first = cp.run.ID
+		}
+		// run IDs are the timestamps, the runs started within the same millisecond would be the same one
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	runs, err := listRuns(d.db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(runs) != _keepRuns || runs[0].ID == first {
+		t.Errorf("got %d runs starting with %s, want the latest %d", len(runs), runs[0].ID, _keepRuns)
+	}
+}
@@ -0,0 +1,57 @@
+package dump
+
+import (
+	"sort"
+
+	"github.com/Neurostep/go-nate/internal/urlnorm"
+	"github.com/konoui/alfred-bookmarks/pkg/bookmarker"
+)
+
+type (
+	// DuplicateGroup is the set of bookmarks collapsing into the same normalized URL.
+	DuplicateGroup struct {
+		URL       string           `json:"url"`
+		Bookmarks []DuplicateEntry `json:"bookmarks"`
+	}
+
+	DuplicateEntry struct {
+		URL     string `json:"url"`
+		Title   string `json:"title"`
+		Folder  string `json:"folder"`
+		Browser string `json:"browser"`
+	}
+)
+
+// FindDuplicates groups the bookmarks by their normalized URL and returns
+// the groups having more than one bookmark, sorted by the URL.
+func FindDuplicates(bms bookmarker.Bookmarks) []DuplicateGroup {
+	groups := map[string]*DuplicateGroup{}
+
+	for _, b := range bms {
+		key := urlnorm.Key(b.URI)
+		g, ok := groups[key]
+		if !ok {
+			g = &DuplicateGroup{URL: key}
+			groups[key] = g
+		}
+		g.Bookmarks = append(g.Bookmarks, DuplicateEntry{
+			URL:     b.URI,
+			Title:   b.Title,
+			Folder:  b.Folder,
+			Browser: string(b.BookmarkerName),
+		})
+	}
+
+	var res []DuplicateGroup
+	for _, g := range groups {
+		if len(g.Bookmarks) > 1 {
+			res = append(res, *g)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].URL < res[j].URL
+	})
+
+	return res
+}
@@ -2,14 +2,25 @@ package dump
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/Neurostep/go-nate/internal/logger"
 
 	"github.com/Neurostep/go-nate/internal/dl"
+	"github.com/Neurostep/go-nate/internal/extract"
+	"github.com/Neurostep/go-nate/internal/failed"
+	"github.com/Neurostep/go-nate/internal/images"
 	"github.com/Neurostep/go-nate/internal/indexer"
+	"github.com/Neurostep/go-nate/internal/lang"
+	"github.com/Neurostep/go-nate/internal/links"
+	"github.com/Neurostep/go-nate/internal/offline"
 	"github.com/Neurostep/go-nate/internal/pool"
-	ua "github.com/Neurostep/go-nate/internal/user-agents"
+	"github.com/Neurostep/go-nate/internal/progress"
+	"github.com/Neurostep/go-nate/internal/robots"
+	"github.com/Neurostep/go-nate/internal/snapshot"
+	"github.com/Neurostep/go-nate/internal/urlnorm"
+	"github.com/Neurostep/go-nate/internal/warc"
 	rw "github.com/Neurostep/readability-wrapper-go/readabilitywrapper"
 	"github.com/aws/jsii-runtime-go"
 	"github.com/dgraph-io/badger/v3"
@@ -19,64 +30,137 @@ import (
 
 	"github.com/abadojack/whatlanggo"
 
-	"github.com/cloudflare/backoff"
-	"go.uber.org/ratelimit"
-	"io/ioutil"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/cheggaaa/pb/v3"
 )
 
 type (
 	Props struct {
-		Logger          *logger.Logger
-		PoolSize        int
-		HttpLoader      *dl.HttpInstance
-		ChromeLoader    *dl.ChromeInstance
-		Bm              bookmarker.Bookmarker
-		UserAgentStream *ua.RandomStream
-		Db              *badger.DB
+		Logger   *logger.Logger
+		PoolSize int
+		// Fetcher fetches the bookmarks, it's expected to be guarded by robots.NewGuard, as well as the fetchers
+		// of Offline and Images, so every request follows robots.txt and the rate limits of its host
+		Fetcher dl.Fetcher
+		Bm      bookmarker.Bookmarker
+		Db      *badger.DB
+		// Robots is consulted before fetching a bookmark, nil means robots.txt is ignored
+		Robots *robots.Cache
+		// KeepSnapshots is the number of content versions kept per bookmark, zero disables snapshots
+		KeepSnapshots int
+		// Warc archives the responses received by the HTTP loader, nil disables archiving
+		Warc *warc.Writer
+		// Offline makes the self-contained snapshot of the extracted content, which is put to OfflineStore,
+		// nil disables offline snapshots
+		Offline      *offline.Inliner
+		OfflineStore *offline.Store
+		// Images captures the favicons and the preview images of the bookmarks, nil disables capturing them
+		Images *images.Capturer
+		// Progress receives the events of the dump, nil discards them
+		Progress progress.Sink
 	}
 
 	Dump struct {
-		mux     sync.Mutex
-		p       *pool.Pool
-		l       *logger.Logger
-		r       rw.ReadabilityWrapper
-		bm      bookmarker.Bookmarker
-		db      *badger.DB
-		httpL   *dl.HttpInstance
-		chromeL *dl.ChromeInstance
-		ua      *ua.RandomStream
+		mux sync.Mutex
+		p   *pool.Pool
+		l   *logger.Logger
+		r   rw.ReadabilityWrapper
+		bm  bookmarker.Bookmarker
+		db  *badger.DB
+		f   dl.Fetcher
+		rc  *robots.Cache
+		sn  *snapshot.Store
+		fs  *failed.Store
+		ln  *links.Store
+		wa  *warc.Writer
+		in  *offline.Inliner
+		os  *offline.Store
+		im  *images.Capturer
+		ld  *lang.Detector
+		pr  progress.Sink
+
+		keepSnapshots int
 	}
 
 	DumpRequest struct {
 		Href, Folder, OriginalTitle string
-		Force                       bool
+		// Aliases are the other URLs of the bookmark, which normalize to the same one as Href
+		Aliases []string
+		Force   bool
+		// Refresh makes a conditional request for the already dumped bookmark,
+		// so it's re-parsed and saved only if it has changed
+		Refresh bool
+	}
+
+	RunOptions struct {
+		Force, Refresh bool
+		// RetryFailed makes the run to dump only the bookmarks which have failed before, instead of all of them
+		RetryFailed bool
+		// Resume continues the latest run, if it was interrupted, with the options it was started with
+		Resume bool
+		// Filter picks the bookmarks of the new run, the resumed run keeps the ones it was started with
+		Filter *Filter
+	}
+
+	// content is what's extracted from the fetched bookmark to be indexed
+	content struct {
+		title, html, text, excerpt, author, site, canonical string
+		// charset is the one the content was transcoded from
+		charset string
+		// meta is what the page tells about itself, it's there for HTML only
+		meta extract.Metadata
+		// icons are the favicons the page declares and image is its preview image, they are there for HTML only
+		icons []string
+		image string
+		// links are the outbound links of the page, they are there for HTML only
+		links []string
 	}
 )
 
 const (
-	defaultRateLimit   = 2
-	backoffMaxDuration = time.Minute * 1
-	backoffInterval    = time.Second * 10
-	backoffMaxAttempts = 3
+	// DefaultRateLimit is the number of the requests per second the host gets, unless it asks for less
+	DefaultRateLimit = 2
+	saveMaxAttempts  = 3
+
+	StatusOK         = "ok"
+	StatusDisallowed = "disallowed by robots"
+	StatusTooLarge   = "too large"
+	// StatusNotModified is not the status of the bookmark, it's the one of the refresh which has found no changes
+	StatusNotModified = "not modified"
 )
 
 func NewDump(props *Props) (*Dump, error) {
 	p := pool.NewPool(props.PoolSize)
 
+	pr := props.Progress
+	if pr == nil {
+		pr = progress.Discard
+	}
+
 	return &Dump{
-		p:       p,
-		ua:      props.UserAgentStream,
-		l:       props.Logger,
-		bm:      props.Bm,
-		db:      props.Db,
-		httpL:   props.HttpLoader,
-		chromeL: props.ChromeLoader,
-		r:       rw.NewReadabilityWrapper(&rw.ReadabilityProps{Name: jsii.String("a")}),
+		p:  p,
+		l:  props.Logger,
+		bm: props.Bm,
+		db: props.Db,
+		f:  props.Fetcher,
+		rc: props.Robots,
+		sn: snapshot.New(props.Db),
+		fs: failed.New(props.Db),
+		ln: links.New(props.Db),
+		wa: props.Warc,
+		in: props.Offline,
+		os: props.OfflineStore,
+		im: props.Images,
+		ld: lang.New(lang.Props{
+			Languages: indexer.SupportedLanguages,
+			Fallback:  whatlanggo.LangToStringShort(whatlanggo.Eng),
+		}),
+		pr: pr,
+		r:  rw.NewReadabilityWrapper(&rw.ReadabilityProps{Name: jsii.String("a")}),
+
+		keepSnapshots: props.KeepSnapshots,
 	}, nil
 }
 
@@ -87,215 +171,704 @@ func (d *Dump) Parse(body, href string) *rw.ReadabilityResult {
 	return d.r.Parse(jsii.String(body), jsii.String(href))
 }
 
-func (d *Dump) Run(ctx context.Context, force bool) error {
+// Run dumps the bookmarks, keeping the checkpoint of the ones which are done, so the run could be resumed
+// with RunOptions.Resume once it's interrupted.
+func (d *Dump) Run(ctx context.Context, opts RunOptions) error {
 	var wg sync.WaitGroup
-	var hostBuckets = map[string]ratelimit.Limiter{}
+	var failures int64
 
-	r, err := d.bm.Bookmarks()
+	cp, reqs, err := d.plan(opts)
 	if err != nil {
 		return err
 	}
+	d.l.Infof("dump run %s has %d bookmarks to dump", cp.run.ID, len(reqs))
 
-	pBar := pb.StartNew(len(r))
+	d.emit(progress.Event{Kind: progress.Started, Total: len(reqs)})
 
-	for _, b := range r {
-		bookmarkExist, err := d.Exists(b.URI)
-		if err != nil {
-			return err
+	for _, req := range reqs {
+		// the bookmarks which are not scheduled yet stay pending, so they are dumped once the run is resumed
+		if ctx.Err() != nil {
+			break
 		}
 
-		if !force && bookmarkExist {
-			pBar.Increment()
+		_, err := url.Parse(req.Href)
+		if err != nil {
+			// the bad URL fails on its own, the rest of the run goes on
+			d.l.Errorf("couldn't parse URL %s: %s", req.Href, err)
+			d.emit(progress.Event{Kind: progress.Failed, URL: req.Href, Reason: err.Error()})
+			atomic.AddInt64(&failures, 1)
+			err = cp.mark(req.Href, true)
+			if err != nil {
+				d.l.Errorf("couldn't checkpoint bookmark %s: %s", req.Href, err)
+			}
 			continue
 		}
 
 		wg.Add(1)
 
-		parsedUrl, err := url.Parse(b.URI)
-		if err != nil {
-			d.l.Errorf("couldn't parse URL: %s", b.URI)
-			return err
-		}
-
-		_, ok := hostBuckets[parsedUrl.Host]
-		if !ok {
-			hostBuckets[parsedUrl.Host] = ratelimit.New(defaultRateLimit)
-		}
-		rl := hostBuckets[parsedUrl.Host]
-
-		func(b *bookmarker.Bookmark) {
+		func(req DumpRequest) {
 			d.p.Schedule(func() {
 				defer func() {
 					wg.Done()
-					pBar.Increment()
 					if x := recover(); x != nil {
-						d.l.Errorf("run time panic: %v. HREF: %s", x, b.URI)
+						d.l.Errorf("run time panic: %v. HREF: %s", x, req.Href)
 					}
 				}()
 
-				rl.Take()
+				// the fetcher waits for the slot of the host
+				err := d.DumpBookmark(ctx, req)
 
-				err := d.DumpBookmark(ctx, DumpRequest{
-					Href:          b.URI,
-					Folder:        b.Folder,
-					OriginalTitle: b.Title,
-					Force:         force,
-				})
+				// interrupted bookmark stays pending, so it's dumped once the run is resumed
+				if ctx.Err() != nil {
+					return
+				}
 				if err != nil {
 					d.l.Errorf("failed dumping bookmark: %s", err)
+					atomic.AddInt64(&failures, 1)
+				}
+				err = cp.mark(req.Href, err != nil)
+				if err != nil {
+					d.l.Errorf("couldn't checkpoint bookmark %s: %s", req.Href, err)
 				}
 			})
-		}(b)
+		}(req)
 	}
 
 	wg.Wait()
-	pBar.Finish()
 
-	return nil
+	finished := progress.Event{Kind: progress.Finished, Total: len(reqs), Failed: int(failures)}
+	if ctx.Err() != nil {
+		finished.Reason = ctx.Err().Error()
+		d.emit(finished)
+		d.l.Infof("dump run %s is interrupted, it could be resumed", cp.run.ID)
+		return nil
+	}
+	d.emit(finished)
+
+	return cp.finish()
+}
+
+// plan returns the checkpoint of the run along with the bookmarks to be dumped, either the pending ones
+// of the interrupted run or the ones of the new run.
+func (d *Dump) plan(opts RunOptions) (*checkpoint, []DumpRequest, error) {
+	if opts.Resume {
+		return d.resumed()
+	}
+
+	all, filtered, err := d.requests(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filtered > 0 {
+		d.l.Infof("%d bookmarks are filtered out", filtered)
+	}
+
+	var reqs []DumpRequest
+	for _, r := range all {
+		bookmarkExist, err := d.Exists(r.Href)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !r.Force && !r.Refresh && bookmarkExist {
+			continue
+		}
+
+		reqs = append(reqs, r)
+	}
+
+	cp, err := startRun(d.db, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cp, reqs, cp.addPending(reqs)
+}
+
+// resumed returns the checkpoint of the latest interrupted run along with its pending bookmarks.
+func (d *Dump) resumed() (*checkpoint, []DumpRequest, error) {
+	cp, err := resumeRun(d.db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reqs, err := cp.pending()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ro := cp.options()
+	for i := range reqs {
+		// failed bookmarks are dumped again even if they were saved before, e.g. by the failed refresh
+		reqs[i].Force = ro.Force || ro.RetryFailed
+		reqs[i].Refresh = ro.Refresh
+	}
+
+	return cp, reqs, nil
+}
+
+// requests returns the requests for all the bookmarks of the new run, the ones which are dumped already included,
+// along with the number of the bookmarks the filter has left out.
+func (d *Dump) requests(opts RunOptions) ([]DumpRequest, int, error) {
+	all, err := d.bookmarks(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kept := make(bookmarker.Bookmarks, 0, len(all))
+	for _, b := range all {
+		if opts.Filter.Keep(b) {
+			kept = append(kept, b)
+		}
+	}
+
+	r, aliases := uniqByKey(kept)
+	force := opts.Force || opts.RetryFailed
+
+	reqs := make([]DumpRequest, 0, len(r))
+	for _, b := range r {
+		reqs = append(reqs, DumpRequest{
+			Href:          b.URI,
+			Folder:        b.Folder,
+			OriginalTitle: b.Title,
+			Aliases:       aliases[urlnorm.Key(b.URI)],
+			Force:         force,
+			Refresh:       opts.Refresh,
+		})
+	}
+
+	return reqs, len(all) - len(kept), nil
 }
 
-func (d *Dump) DumpBookmark(ctx context.Context, req DumpRequest) error {
-	bookmarkExist, err := d.Exists(req.Href)
+// bookmarks returns the bookmarks to be dumped by the run.
+func (d *Dump) bookmarks(opts RunOptions) (bookmarker.Bookmarks, error) {
+	if !opts.RetryFailed {
+		return d.bm.Bookmarks()
+	}
+
+	fs, err := d.fs.List()
+	if err != nil {
+		return nil, err
+	}
+
+	bms := make(bookmarker.Bookmarks, 0, len(fs))
+	for _, f := range fs {
+		bms = append(bms, &bookmarker.Bookmark{URI: f.URL, Folder: f.Folder, Title: f.Title})
+	}
+
+	return bms, nil
+}
+
+// DumpBookmark fetches the bookmark and saves its content. The failure is recorded, so the bookmark
+// could be retried later on, and forgotten once the bookmark is dumped successfully.
+func (d *Dump) DumpBookmark(ctx context.Context, req DumpRequest) (err error) {
+	defer func() {
+		// interrupted dump tells nothing about the bookmark
+		if ctx.Err() != nil {
+			return
+		}
+
+		var trackErr error
+		if err != nil {
+			d.emit(progress.Event{Kind: progress.Failed, URL: req.Href, Reason: err.Error()})
+			trackErr = d.fs.Add(failed.Failure{
+				URL:    req.Href,
+				Folder: req.Folder,
+				Title:  req.OriginalTitle,
+				Error:  err.Error(),
+			})
+		} else {
+			trackErr = d.fs.Remove(req.Href)
+		}
+		if trackErr != nil {
+			d.l.Errorf("couldn't track failure of bookmark %s: %s", req.Href, trackErr)
+		}
+	}()
+
+	return d.dumpBookmark(ctx, req)
+}
+
+func (d *Dump) dumpBookmark(ctx context.Context, req DumpRequest) error {
+	prev, err := d.Load(req.Href)
 	if err != nil {
 		return err
 	}
 
-	if !req.Force && bookmarkExist {
+	if !req.Force && !req.Refresh && prev != nil {
 		return nil
 	}
 
-	attempts := 0
+	// conditional request makes sense only if there is something to compare with
+	refresh := req.Refresh && !req.Force && prev != nil
 
-	back := backoff.New(backoffMaxDuration, backoffInterval)
-	defer back.Reset()
+	if d.rc != nil {
+		allowed, err := d.rc.Allowed(ctx, req.Href)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			d.l.Infof("bookmark is disallowed by robots.txt, HREF: %s", req.Href)
+
+			// the bookmark dumped before keeps what it's got, only the title of the new one is known
+			r := carry(prev)
+			if prev == nil {
+				content, title := d.langs(prev, req.OriginalTitle)
+				r[fmt.Sprintf("%s_title", title.Lang)] = req.OriginalTitle
+				setLangs(r, prev, content, title)
+			}
+			r["aliases"] = mergeAliases(prev.Strings("aliases"), req.Href, req.Aliases)
+			r["url"] = req.Href
+			r["folder"] = req.Folder
+			r["status"] = StatusDisallowed
+			err = d.Save(r)
+			if err != nil {
+				return err
+			}
+			d.emit(progress.Event{Kind: progress.Saved, URL: req.Href, Status: StatusDisallowed})
 
-	var body []byte
+			return nil
+		}
+	}
+
+	fetchCtx := ctx
+	if refresh {
+		fetchCtx = dl.WithValidators(ctx, dl.Validators{
+			ETag:         prev.String("etag"),
+			LastModified: prev.String("last_modified"),
+		})
+	}
+
+	res, err := d.f.Fetch(fetchCtx, req.Href)
+	if err != nil {
+		return err
+	}
 
-	for {
-		attempts++
+	d.emitFetched(req, res)
 
-		uaString, err := d.ua.Get()
+	var attempts []string
+	for _, st := range res.Steps {
+		if st.Err != nil {
+			d.l.Debugf("%s loader failed for HREF: %s, %s", st.Loader, req.Href, st.Err)
+		} else if st.StatusCode != http.StatusOK {
+			d.l.Debugf("%s loader received non-200 HTTP code: %d. HREF: %s", st.Loader, st.StatusCode, req.Href)
+		}
+		for i, a := range st.Attempts {
+			if len(st.Attempts) > 1 {
+				d.l.Debugf("%s loader attempt %d: %s. HREF: %s", st.Loader, i+1, a, req.Href)
+			}
+			attempts = append(attempts, fmt.Sprintf("%s: %s", st.Loader, a))
+		}
+	}
+
+	fetchedAt := time.Now().UTC().Format(time.RFC3339)
+	contentHash := hashOf(res.Body)
+
+	if refresh && (res.NotModified() || contentHash == prev.String("content_hash")) {
+		d.l.Debugf("bookmark hasn't changed since %s, HREF: %s", prev.String("fetched_at"), req.Href)
+
+		prev["fetched_at"] = fetchedAt
+		err = d.Save(prev)
 		if err != nil {
-			return errors.Wrap(err, "couldn't get User Agent")
+			return errors.Wrapf(err, "couldn't save bookmark %s", req.Href)
 		}
+		d.emit(progress.Event{Kind: progress.Saved, URL: req.Href, Status: StatusNotModified})
+
+		return nil
+	}
 
-		r, err := d.httpL.Get(ctx, req.Href, uaString)
+	if res.TooLarge {
+		d.l.Infof("bookmark is too large to download (%d bytes), HREF: %s", res.Size, req.Href)
+
+		err = d.saveTooLarge(req, res, prev, attempts, fetchedAt)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "couldn't save bookmark %s", req.Href)
 		}
+		d.emit(progress.Event{Kind: progress.Saved, URL: req.Href, Status: StatusTooLarge})
 
-		body, err = ioutil.ReadAll(r.Body)
+		return nil
+	}
+
+	archived, err := d.archive(res, prev)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't archive bookmark %s", req.Href)
+	}
+
+	body := res.Body
+	if len(body) == 0 {
+		d.l.Error(errors.Errorf("body is empty for HREF: %s. Status is %d", req.Href, res.StatusCode))
+	}
+
+	var c content
+	if len(body) > 0 {
+		c, err = d.extract(req, res)
 		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("couldn't read response body for HREF: %s", req.Href))
+			return errors.Wrapf(err, "couldn't extract content of bookmark %s", req.Href)
 		}
+	}
+	// the main text tells the language of the content best, the title may well be in the other one
+	contentLang, titleLang := d.langs(prev, c.title, c.text, c.excerpt)
+
+	bmJson := Record{
+		"author":        c.author,
+		"siteName":      c.site,
+		"url":           req.Href,
+		"folder":        req.Folder,
+		"status":        StatusOK,
+		"etag":          res.Validators().ETag,
+		"last_modified": res.Validators().LastModified,
+		"fetched_at":    fetchedAt,
+		"content_hash":  contentHash,
+		"final_url":     res.FinalURL,
+		"redirects":     res.Redirects,
+		"canonical_url": c.canonical,
+		"charset":       c.charset,
+		"attempts":      attempts,
+		"aliases":       mergeAliases(prev.Strings("aliases"), req.Href, req.Aliases),
+	}
+	setLangs(bmJson, prev, contentLang, titleLang)
+	// the content is kept in the fields named after its language, so it's analyzed as the text in that language
+	bmJson[fmt.Sprintf("%s_title", titleLang.Lang)] = c.title
+	bmJson[fmt.Sprintf("%s_html", contentLang.Lang)] = c.html
+	bmJson[fmt.Sprintf("%s_text", contentLang.Lang)] = c.text
+	bmJson[fmt.Sprintf("%s_excerpt", contentLang.Lang)] = c.excerpt
+	keepHealth(bmJson, prev)
+
+	for k, v := range c.meta.Fields() {
+		bmJson[k] = v
+	}
 
-		if r.StatusCode != http.StatusOK {
-			if (r.StatusCode == http.StatusForbidden || r.StatusCode == http.StatusServiceUnavailable) && attempts < backoffMaxAttempts {
-				nextTry := back.Duration()
-				<-time.After(nextTry)
-				continue
-			}
-			d.l.Debugf("received non-200 HTTP code: %d. HREF: %s, attempts: %d, trying the chrome...", r.StatusCode, req.Href, attempts)
+	if d.in != nil && c.html != "" {
+		err = d.saveOffline(ctx, req, c, fetchedAt)
+		if err != nil {
+			d.l.Errorf("couldn't save offline snapshot of bookmark %s: %s", req.Href, err)
+		} else {
+			bmJson["offline"] = true
+		}
+	} else if prev.Bool("offline") {
+		// the snapshot made before is still there
+		bmJson["offline"] = true
+	}
 
-			chromeResp, err := d.chromeL.Get(ctx, req.Href)
-			if err != nil {
-				d.l.Error(errors.Wrapf(err, "got error while using chrome for HREF %s", req.Href))
+	if d.im != nil && len(body) > 0 {
+		d.captureImages(ctx, req, res, c, prev, bmJson)
+	} else {
+		// the images captured before are still there
+		for _, k := range []string{"favicon_id", "image_id", "image_url"} {
+			if v := prev.String(k); v != "" {
+				bmJson[k] = v
 			}
+		}
+	}
 
-			if chromeResp != nil && chromeResp.Body != nil {
-				body, err = ioutil.ReadAll(chromeResp.Body)
-				if err != nil {
-					d.l.Error(errors.Wrapf(err, "couldn't read response body for HREF: %s", req.Href))
-				}
-			}
+	if archived != nil {
+		bmJson["warc_file"] = archived.File
+		bmJson["warc_offset"] = archived.Offset
+		bmJson["warc_length"] = archived.Length
+	}
+
+	err = d.Save(bmJson)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't save bookmark %s", req.Href)
+	}
+
+	err = d.ln.Put(req.Href, c.links)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't save links of bookmark %s", req.Href)
+	}
+
+	if d.keepSnapshots > 0 && len(body) > 0 {
+		err = d.sn.Add(urlnorm.Key(req.Href), snapshot.Snapshot{
+			FetchedAt:   fetchedAt,
+			Title:       c.title,
+			Text:        c.text,
+			ContentHash: contentHash,
+		}, d.keepSnapshots)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't save snapshot of bookmark %s", req.Href)
 		}
+	}
+	d.emit(progress.Event{Kind: progress.Saved, URL: req.Href, Status: StatusOK})
+
+	return nil
+}
 
-		if body == nil {
-			d.l.Error(errors.Errorf("body is nil for HREF: %s. Status is %s", req.Href, r.Status))
+// emitFetched tells which fetcher has fetched the bookmark, along with the ones the chain has fallen back from.
+func (d *Dump) emitFetched(req DumpRequest, res *dl.Result) {
+	for i := 1; i < len(res.Steps); i++ {
+		prev := res.Steps[i-1]
+		reason := fmt.Sprintf("%s loader responded with %d", prev.Loader, prev.StatusCode)
+		if prev.Err != nil {
+			reason = fmt.Sprintf("%s loader failed: %s", prev.Loader, prev.Err)
 		}
+		d.emit(progress.Event{Kind: progress.FellBack, URL: req.Href, Loader: res.Steps[i].Loader, Reason: reason})
+	}
+
+	d.emit(progress.Event{Kind: progress.Fetched, URL: req.Href, Loader: res.Loader, StatusCode: res.StatusCode})
+}
 
-		break
+func (d *Dump) emit(e progress.Event) {
+	e.Stage = progress.StageDump
+	e.Time = time.Now().UTC()
+	d.pr.Emit(e)
+}
+
+// saveTooLarge saves the metadata of the bookmark, the body of which is too large to be downloaded. The bookmark
+// dumped before keeps its content, it's the metadata of the response which is updated.
+func (d *Dump) saveTooLarge(req DumpRequest, res *dl.Result, prev Record, attempts []string, fetchedAt string) error {
+	r := carry(prev)
+	if prev == nil {
+		content, title := d.langs(prev, req.OriginalTitle)
+		r[fmt.Sprintf("%s_title", title.Lang)] = req.OriginalTitle
+		setLangs(r, prev, content, title)
+	}
+	r["url"] = req.Href
+	r["folder"] = req.Folder
+	r["status"] = StatusTooLarge
+	r["content_type"] = res.Header.Get("Content-Type")
+	r["fetched_at"] = fetchedAt
+	r["final_url"] = res.FinalURL
+	r["redirects"] = res.Redirects
+	r["attempts"] = attempts
+	r["aliases"] = mergeAliases(prev.Strings("aliases"), req.Href, req.Aliases)
+	delete(r, "content_length")
+	if res.Size > 0 {
+		r["content_length"] = res.Size
 	}
 
-	var pr = &rw.ReadabilityResult{}
-	if body != nil {
-		pr = d.Parse(string(body), req.Href)
+	return d.Save(r)
+}
+
+// archive writes the response to the WARC file, if it's the one received by the HTTP loader. Otherwise the record
+// written before is returned, as the bookmark is still archived there.
+func (d *Dump) archive(res *dl.Result, prev Record) (*warc.Location, error) {
+	if d.wa == nil {
+		return nil, nil
+	}
+
+	if res.Loader != dl.HttpLoaderName {
+		return warcLocation(prev), nil
+	}
+
+	loc, err := d.wa.WriteResponse(res, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &loc, nil
+}
+
+// captureImages captures the favicon of the bookmark host along with its preview image. Neither of them failing
+// fails the bookmark, it's saved without the image.
+func (d *Dump) captureImages(ctx context.Context, req DumpRequest, res *dl.Result, c content, prev, b Record) {
+	pageURL := res.FinalURL
+	if pageURL == "" {
+		pageURL = req.Href
+	}
+
+	favicon, err := d.im.Favicon(ctx, pageURL, c.icons, req.Force)
+	if err != nil {
+		d.l.Debugf("couldn't capture favicon: %s. HREF: %s", err, req.Href)
+	} else if favicon != "" {
+		b["favicon_id"] = favicon
+	}
+
+	if c.image == "" {
+		return
+	}
+	b["image_url"] = c.image
+
+	// the image of the same URL is not downloaded again, unless the bookmark is forced
+	if !req.Force && c.image == prev.String("image_url") && prev.String("image_id") != "" {
+		b["image_id"] = prev.String("image_id")
+		return
+	}
+
+	image, err := d.im.Image(ctx, c.image)
+	if err != nil {
+		d.l.Debugf("couldn't capture preview image: %s. HREF: %s", err, req.Href)
+		return
+	}
+	b["image_id"] = image
+}
+
+// saveOffline inlines the assets of the extracted content and stores the resulting page.
+func (d *Dump) saveOffline(ctx context.Context, req DumpRequest, c content, fetchedAt string) error {
+	page, stats, err := d.in.Inline(ctx, offline.Page{
+		URL:       req.Href,
+		Title:     c.title,
+		HTML:      c.html,
+		FetchedAt: fetchedAt,
+	})
+	if err != nil {
+		return err
+	}
+	d.l.Debugf("offline snapshot has %d assets inlined (%d bytes), %d left as links. HREF: %s",
+		stats.Inlined, stats.Size, stats.Skipped, req.Href)
+
+	return d.os.Put(req.Href, page)
+}
+
+// extract picks the content of the bookmark out of the response, according to its format.
+func (d *Dump) extract(req DumpRequest, res *dl.Result) (content, error) {
+	href := res.FinalURL
+	if href == "" {
+		href = req.Href
 	}
 
-	var title, html, text, excerpt, author, site string
+	contentType := res.Header.Get("Content-Type")
+	kind := extract.Detect(contentType, href, res.Body)
+	if kind == extract.KindPDF {
+		doc, err := extract.PDF(res.Body)
+		if err != nil {
+			return content{}, err
+		}
+
+		return fromDocument(req, doc), nil
+	}
+
+	// the rest of the formats are text, which is parsed once it's in UTF-8
+	body, cs, err := extract.ToUTF8(res.Body, contentType)
+	if err != nil {
+		return content{}, err
+	}
+	if cs != extract.UTF8 {
+		d.l.Debugf("transcoded bookmark from %s, HREF: %s", cs, req.Href)
+	}
+
+	var (
+		c   content
+		doc *extract.Document
+	)
+	switch kind {
+	case extract.KindHTML:
+		c = d.extractHTML(req, body, res.FinalURL)
+	case extract.KindMarkdown:
+		doc, err = extract.Markdown(body)
+	case extract.KindJSON:
+		doc = extract.JSON(body)
+	case extract.KindXML:
+		doc = extract.XML(body)
+	default:
+		doc = extract.PlainText(body)
+	}
+	if err != nil {
+		return content{}, err
+	}
+	if doc != nil {
+		c = fromDocument(req, doc)
+	}
+	c.charset = cs
+
+	return c, nil
+}
+
+func fromDocument(req DumpRequest, doc *extract.Document) content {
+	c := content{title: doc.Title, html: doc.HTML, text: doc.Text, author: doc.Author}
+	if c.title == "" {
+		c.title = req.OriginalTitle
+	}
+
+	return c
+}
+
+// extractHTML parses the page with readability, the body is expected to be in UTF-8.
+func (d *Dump) extractHTML(req DumpRequest, body []byte, finalURL string) content {
+	pr := d.Parse(string(body), req.Href)
+
+	base := finalURL
+	if base == "" {
+		base = req.Href
+	}
+
+	c := content{
+		canonical: extract.Canonical(body, finalURL),
+		meta:      extract.ParseMetadata(body),
+		icons:     extract.Icons(body, base),
+		links:     extract.Links(body, base),
+	}
+	c.image = c.meta.Image(base)
 	if pr.Title != nil {
-		title = *pr.Title
-		if title == "" {
-			title = req.OriginalTitle
+		c.title = *pr.Title
+		if c.title == "" {
+			c.title = req.OriginalTitle
 		}
 	}
 	if pr.Content != nil {
-		html = *pr.Content
+		c.html = *pr.Content
 	}
 	if pr.TextContent != nil {
-		text = *pr.TextContent
+		c.text = *pr.TextContent
 	}
 	if pr.Excerpt != nil {
-		excerpt = *pr.Excerpt
+		c.excerpt = *pr.Excerpt
 	}
 	if pr.Byline != nil {
-		author = *pr.Byline
+		c.author = *pr.Byline
 	}
 	if pr.SiteName != nil {
-		site = *pr.SiteName
-	}
-
-	var lang string
-	langSpecificFields := []string{text, excerpt, title}
-	for _, t := range langSpecificFields {
-		if t != "" {
-			langInfo := whatlanggo.Detect(t)
-			if langInfo.Confidence > 0.95 {
-				lang = whatlanggo.LangToStringShort(langInfo.Lang)
-			} else {
-				lang = whatlanggo.LangToStringShort(whatlanggo.Eng)
-			}
-		}
+		c.site = *pr.SiteName
 	}
 
-	_, langSupported := indexer.SupportedLanguages[lang]
-	if lang == "" || !langSupported {
-		lang = whatlanggo.LangToStringShort(whatlanggo.Eng)
+	// readability looks at some of the metadata, but not at all of it
+	if c.excerpt == "" {
+		c.excerpt = c.meta.Description
 	}
-
-	bmJson := map[string]string{
-		fmt.Sprintf("%s_title", lang):   title,
-		fmt.Sprintf("%s_html", lang):    html,
-		fmt.Sprintf("%s_text", lang):    text,
-		fmt.Sprintf("%s_excerpt", lang): excerpt,
-		"lang":                          lang,
-		"author":                        author,
-		"siteName":                      site,
-		"url":                           req.Href,
-		"folder":                        req.Folder,
+	if c.author == "" {
+		c.author = strings.Join(c.meta.SchemaAuthors, ", ")
+	}
+	if c.site == "" {
+		c.site = c.meta.OpenGraph["site_name"]
 	}
 
-	err = d.Save(bmJson)
-
-	return errors.Wrapf(err, "couldn't save file %s", *pr.Title)
+	return c
 }
 
-func (d *Dump) Save(b map[string]string) error {
-	return d.db.Update(func(txn *badger.Txn) error {
-		bm, err := json.Marshal(&b)
-		if err != nil {
-			return err
+// Save stores the bookmark and flags the ones resolving to the same page as duplicates.
+func (d *Dump) Save(b Record) error {
+	var err error
+
+	// bookmarks sharing the canonical URL are updated together, so concurrent saves may conflict
+	for i := 0; i < saveMaxAttempts; i++ {
+		err = d.db.Update(func(txn *badger.Txn) error {
+			prev, err := loadRecord(txn, b.String("url"))
+			if err != nil {
+				return err
+			}
+
+			err = updateDuplicates(txn, b, prev)
+			if err != nil {
+				return err
+			}
+
+			return saveRecord(txn, b)
+		})
+		if err != badger.ErrConflict {
+			break
 		}
+	}
+
+	return err
+}
+
+// Load returns the dumped bookmark, nil if it doesn't exist.
+func (d *Dump) Load(href string) (Record, error) {
+	var b Record
+
+	err := d.db.View(func(txn *badger.Txn) error {
+		var err error
+		b, err = loadRecord(txn, href)
 
-		return txn.Set([]byte(b["url"]), bm)
+		return err
 	})
+
+	return b, err
 }
 
 func (d *Dump) Exists(href string) (bool, error) {
 	var exists bool
 
 	err := d.db.View(func(txn *badger.Txn) error {
-		_, err := txn.Get([]byte(href))
+		_, err := txn.Get([]byte(urlnorm.Key(href)))
 		if err != nil && err != badger.ErrKeyNotFound {
 			return err
 		}
@@ -306,3 +879,44 @@ func (d *Dump) Exists(href string) (bool, error) {
 
 	return exists, err
 }
+
+// uniqByKey collapses the bookmarks normalizing to the same URL. The first one of them is kept,
+// URLs of the others are returned as its aliases, by the normalized URL.
+func uniqByKey(bms bookmarker.Bookmarks) (bookmarker.Bookmarks, map[string][]string) {
+	var uniq bookmarker.Bookmarks
+	aliases := map[string][]string{}
+
+	for _, b := range bms {
+		key := urlnorm.Key(b.URI)
+		if _, ok := aliases[key]; !ok {
+			uniq = append(uniq, b)
+			aliases[key] = []string{}
+		}
+		aliases[key] = append(aliases[key], b.URI)
+	}
+
+	return uniq, aliases
+}
+
+// mergeAliases returns the union of the known aliases, the bookmark URL and its new aliases.
+func mergeAliases(known []string, href string, more []string) []string {
+	seen := map[string]bool{}
+	res := []string{}
+
+	all := append([]string{}, known...)
+	all = append(all, href)
+	for _, a := range append(all, more...) {
+		if !seen[a] {
+			seen[a] = true
+			res = append(res, a)
+		}
+	}
+
+	return res
+}
+
+func hashOf(body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,162 @@
+package dump
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+	"github.com/Neurostep/go-nate/internal/logger"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/konoui/alfred-bookmarks/pkg/bookmarker"
+	"go.uber.org/zap"
+)
+
+type bookmarks bookmarker.Bookmarks
+
+func (b bookmarks) Bookmarks() (bookmarker.Bookmarks, error) {
+	return bookmarker.Bookmarks(b), nil
+}
+
+func newTestDump(t *testing.T, f dl.Fetcher, bms bookmarks) *Dump {
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("couldn't open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	d, err := NewDump(&Props{
+		Logger:   &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+		PoolSize: 1,
+		Fetcher:  f,
+		Bm:       bms,
+		Db:       db,
+	})
+	if err != nil {
+		t.Fatalf("couldn't create dump: %v", err)
+	}
+
+	return d
+}
+
+func textPage(ctx context.Context, url string) (*dl.Result, error) {
+	h := http.Header{}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("ETag", `"v1"`)
+
+	return &dl.Result{
+		URL:        url,
+		FinalURL:   url,
+		Loader:     "http",
+		StatusCode: http.StatusOK,
+		Header:     h,
+		Body:       []byte("The quick brown fox jumps over the lazy dog."),
+	}, nil
+}
+
+func TestDumpBookmark(t *testing.T) {
+	var fetched int
+	d := newTestDump(t, dl.FetcherFunc(func(ctx context.Context, url string) (*dl.Result, error) {
+		fetched++
+		return textPage(ctx, url)
+	}), nil)
+
+	req := DumpRequest{Href: "http://Example.com/page/?utm_source=x", Folder: "news", Aliases: []string{"https://example.com/page"}}
+	err := d.DumpBookmark(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := d.Load("https://example.com/page")
+	if err != nil || b == nil {
+		t.Fatalf("bookmark isn't saved under its normalized URL: %v", err)
+	}
+	if b.String("status") != StatusOK || b.String("url") != req.Href || b.String("folder") != "news" {
+		t.Errorf("unexpected bookmark %v", b)
+	}
+	if b.String("etag") != `"v1"` || b.String("content_hash") == "" {
+		t.Errorf("validators aren't kept: %v", b)
+	}
+	if aliases := b.Strings("aliases"); len(aliases) != 2 {
+		t.Errorf("got aliases %v, want the URL and its alias", aliases)
+	}
+
+	// the dumped bookmark isn't fetched again unless it's forced or refreshed
+	err = d.DumpBookmark(context.Background(), req)
+	if err != nil || fetched != 1 {
+		t.Errorf("got %v after %d fetches, want the single fetch", err, fetched)
+	}
+}
+
+func TestDumpBookmarkFailure(t *testing.T) {
+	errFetch := errors.New("connection refused")
+	fail := true
+	d := newTestDump(t, dl.FetcherFunc(func(ctx context.Context, url string) (*dl.Result, error) {
+		if fail {
+			return nil, errFetch
+		}
+		return textPage(ctx, url)
+	}), nil)
+
+	req := DumpRequest{Href: "https://example.com/", Folder: "news", OriginalTitle: "Example"}
+	err := d.DumpBookmark(context.Background(), req)
+	if !errors.Is(err, errFetch) {
+		t.Fatalf("got error %v, want %v", err, errFetch)
+	}
+	if b, _ := d.Load(req.Href); b != nil {
+		t.Errorf("failed bookmark is saved: %v", b)
+	}
+
+	fs, err := d.fs.List()
+	if err != nil || len(fs) != 1 || fs[0].URL != req.Href || fs[0].Title != "Example" {
+		t.Fatalf("got failures %v, %v, want the bookmark's one", fs, err)
+	}
+
+	// the failure is forgotten once the bookmark is dumped
+	fail = false
+	err = d.DumpBookmark(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fs, err = d.fs.List()
+	if err != nil || len(fs) != 0 {
+		t.Errorf("got failures %v, %v, want none", fs, err)
+	}
+}
+
+func TestDumpBookmarkNotModified(t *testing.T) {
+	notModified := false
+	d := newTestDump(t, dl.FetcherFunc(func(ctx context.Context, url string) (*dl.Result, error) {
+		if notModified {
+			return &dl.Result{URL: url, StatusCode: http.StatusNotModified, Header: http.Header{}}, nil
+		}
+		return textPage(ctx, url)
+	}), nil)
+
+	req := DumpRequest{Href: "https://example.com/"}
+	err := d.DumpBookmark(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	prev, _ := d.Load(req.Href)
+	prev["fetched_at"] = "2001-01-01T00:00:00Z"
+	err = d.Save(prev)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	notModified = true
+	req.Refresh = true
+	err = d.DumpBookmark(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, _ := d.Load(req.Href)
+	if b.String("fetched_at") == "2001-01-01T00:00:00Z" || b.String("content_hash") != prev.String("content_hash") {
+		t.Errorf("unchanged bookmark isn't kept as it was, got %v", b)
+	}
+}
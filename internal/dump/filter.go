@@ -0,0 +1,213 @@
+package dump
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/konoui/alfred-bookmarks/pkg/bookmarker"
+	"github.com/pkg/errors"
+)
+
+type (
+	// FilterProps are the filters the bookmarks of the run are picked by. Every kind of the include filters
+	// which is given has to be matched by at least one of its filters, and none of the exclude filters.
+	FilterProps struct {
+		// Folders and ExcludeFolders are the folders, along with their subfolders, e.g. "Work/Docs". The folder
+		// is found anywhere in the bookmark tree, unless it starts with "/", then it's looked up from the root.
+		Folders, ExcludeFolders []string
+		// Domains and ExcludeDomains are the hosts, along with their subdomains, e.g. "example.com" or "localhost".
+		Domains, ExcludeDomains []string
+		// Match are the patterns of the bookmark URL, either globs where "*" matches any number of any characters
+		// and "?" a single one, or regular expressions prefixed with "re:".
+		Match []string
+	}
+
+	// Filter picks the bookmarks of the run.
+	Filter struct {
+		folders, excludeFolders [][]string
+		domains, excludeDomains []string
+		match                   []*regexp.Regexp
+	}
+)
+
+const (
+	regexpPrefix = "re:"
+)
+
+func NewFilter(props FilterProps) (*Filter, error) {
+	f := &Filter{
+		folders:        folderPaths(props.Folders),
+		excludeFolders: folderPaths(props.ExcludeFolders),
+		domains:        domainNames(props.Domains),
+		excludeDomains: domainNames(props.ExcludeDomains),
+	}
+
+	for _, m := range props.Match {
+		re, err := matchPattern(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad match pattern %q", m)
+		}
+		f.match = append(f.match, re)
+	}
+
+	return f, nil
+}
+
+// Keep tells if the bookmark passes the filter. Nil filter keeps all the bookmarks.
+func (f *Filter) Keep(b *bookmarker.Bookmark) bool {
+	if f == nil {
+		return true
+	}
+
+	folder := splitFolder(b.Folder)
+	host := hostOf(b.URI)
+
+	if len(f.folders) > 0 && !anyFolder(f.folders, folder) {
+		return false
+	}
+	if len(f.domains) > 0 && !anyDomain(f.domains, host) {
+		return false
+	}
+	if len(f.match) > 0 && !anyMatch(f.match, b.URI) {
+		return false
+	}
+
+	return !anyFolder(f.excludeFolders, folder) && !anyDomain(f.excludeDomains, host)
+}
+
+// folderPaths splits the folders into their names, the root anchored ones start with the empty name.
+func folderPaths(folders []string) [][]string {
+	var res [][]string
+	for _, f := range folders {
+		names := splitFolder(f)
+		if len(names) == 0 {
+			continue
+		}
+		if strings.HasPrefix(f, "/") {
+			names = append([]string{""}, names...)
+		}
+		res = append(res, names)
+	}
+
+	return res
+}
+
+func splitFolder(folder string) []string {
+	var names []string
+	for _, n := range strings.Split(folder, "/") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+
+	return names
+}
+
+// anyFolder tells if the folder, or any of its parents, is one of the filter folders.
+func anyFolder(filters [][]string, folder []string) bool {
+	for _, f := range filters {
+		if f[0] == "" {
+			if hasFolderAt(folder, f[1:], 0) {
+				return true
+			}
+			continue
+		}
+
+		for i := range folder {
+			if hasFolderAt(folder, f, i) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hasFolderAt(folder, names []string, at int) bool {
+	if len(folder)-at < len(names) {
+		return false
+	}
+	for i, n := range names {
+		if !strings.EqualFold(folder[at+i], n) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func domainNames(domains []string) []string {
+	var res []string
+	for _, d := range domains {
+		if d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), "."); d != "" {
+			res = append(res, d)
+		}
+	}
+
+	return res
+}
+
+// hostOf returns the host of the bookmark URL, without the port, empty if the URL has no host.
+func hostOf(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// anyDomain tells if the host is one of the filter domains or their subdomain. IP addresses match
+// the exact filters only.
+func anyDomain(domains []string, host string) bool {
+	if host == "" {
+		return false
+	}
+
+	for _, d := range domains {
+		if host == d {
+			return true
+		}
+		if net.ParseIP(host) == nil && strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func anyMatch(patterns []*regexp.Regexp, href string) bool {
+	for _, re := range patterns {
+		if re.MatchString(href) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPattern compiles the pattern of the URL. The regular expression matches any part of the URL,
+// while the glob has to match it whole.
+func matchPattern(p string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(p, regexpPrefix) {
+		return regexp.Compile(strings.TrimPrefix(p, regexpPrefix))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range p {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+
+	return regexp.Compile(sb.String())
+}
@@ -0,0 +1,124 @@
+package dump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Neurostep/go-nate/internal/lang"
+	"github.com/pkg/errors"
+)
+
+// LangOverride is the language of the bookmark set manually. The empty languages are left as they are, Reset drops
+// the languages set before, so the detected ones are used again.
+type LangOverride struct {
+	Lang, TitleLang string
+	Reset           bool
+}
+
+var (
+	// contentFields are the fields of the bookmark content, they are named after the language they are in
+	contentFields = []string{"text", "excerpt", "html"}
+)
+
+// langs returns the languages of the bookmark content and of its title. The ones set manually before win
+// over the detected ones.
+func (d *Dump) langs(prev Record, title string, texts ...string) (content lang.Detection, titleDet lang.Detection) {
+	content, titleDet = d.ld.Document(title, texts...)
+	if l := prev.String("lang_override"); l != "" {
+		content = lang.Detection{Lang: l, Confidence: 1, Script: content.Script}
+		if titleDet.Confidence == 0 {
+			// the title is assumed to be in the content language
+			titleDet.Lang = l
+		}
+	}
+	if l := prev.String("title_lang_override"); l != "" {
+		titleDet = lang.Detection{Lang: l, Confidence: 1, Script: titleDet.Script}
+	}
+
+	return content, titleDet
+}
+
+// setLangs records the languages of the bookmark, along with the ones set manually before.
+func setLangs(r, prev Record, content, title lang.Detection) {
+	r["lang"] = content.Lang
+	r["lang_confidence"] = content.Confidence
+	r["lang_script"] = content.Script
+	r["title_lang"] = title.Lang
+	r["title_lang_confidence"] = title.Confidence
+
+	for _, k := range []string{"lang_override", "title_lang_override"} {
+		if v := prev.String(k); v != "" {
+			r[k] = v
+		}
+	}
+}
+
+// SetLang sets the languages of the dumped bookmark manually, they are kept by the later dumps. The content
+// and the title are moved to the fields of the new languages, so the bookmark has to be indexed again.
+func (d *Dump) SetLang(href string, o LangOverride) (Record, error) {
+	for _, l := range []string{o.Lang, o.TitleLang} {
+		if l != "" && !d.ld.Supported(l) {
+			return nil, errors.Errorf("language %q is not supported, it's one of %s", l, strings.Join(d.ld.Languages(), ", "))
+		}
+	}
+
+	r, err := d.Load(href)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, errors.Errorf("bookmark %s is not dumped", href)
+	}
+
+	contentLang, titleLang := r.String("lang"), titleLangOf(r)
+	r["title_lang"] = titleLang
+	if o.Reset {
+		delete(r, "lang_override")
+		delete(r, "title_lang_override")
+
+		content, title := d.langs(r, r.String(titleLang+"_title"), r.String(contentLang+"_text"), r.String(contentLang+"_excerpt"))
+		setLangs(r, r, content, title)
+	}
+	if o.Lang != "" {
+		r["lang"] = o.Lang
+		r["lang_confidence"] = 1.0
+		r["lang_override"] = o.Lang
+	}
+	if o.TitleLang != "" {
+		r["title_lang"] = o.TitleLang
+		r["title_lang_confidence"] = 1.0
+		r["title_lang_override"] = o.TitleLang
+	}
+
+	relocate(r, contentLang, r.String("lang"), contentFields...)
+	relocate(r, titleLang, r.String("title_lang"), "title")
+
+	return r, d.Save(r)
+}
+
+// titleLangOf returns the language of the bookmark title. The bookmarks dumped before the title got its own
+// language have the title in the content one.
+func titleLangOf(r Record) string {
+	if l := r.String("title_lang"); l != "" {
+		return l
+	}
+
+	return r.String("lang")
+}
+
+// relocate moves the fields named after the language to the ones named after the other language.
+func relocate(r Record, from, to string, fields ...string) {
+	if from == to {
+		return
+	}
+
+	for _, f := range fields {
+		k := fmt.Sprintf("%s_%s", from, f)
+		v, ok := r[k]
+		if !ok {
+			continue
+		}
+		delete(r, k)
+		r[fmt.Sprintf("%s_%s", to, f)] = v
+	}
+}
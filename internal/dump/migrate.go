@@ -0,0 +1,127 @@
+package dump
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/Neurostep/go-nate/internal/snapshot"
+	"github.com/Neurostep/go-nate/internal/urlnorm"
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	_migrationNamespace = "migration"
+	_normalizedKeys     = "normalized-keys"
+)
+
+// MigrateKeys moves the bookmarks, along with their snapshots, stored under their raw URLs before the URLs were
+// normalized, to the keys of their normalized URLs. The bookmarks collapsing into the same normalized URL are merged
+// into one, the URLs of the others become its aliases, the same way the dump merges them. It's done once per DB,
+// the number of the moved bookmarks is returned.
+func MigrateKeys(db *badger.DB) (int, error) {
+	marker := keys.Key(_migrationNamespace, _normalizedKeys)
+
+	var (
+		done bool
+		// moved are the records by the raw keys they are stored under
+		moved = map[string]Record{}
+		// merged are the records by the normalized keys they are moved to
+		merged = map[string]Record{}
+	)
+
+	err := db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(marker)
+		if err == nil {
+			done = true
+			return nil
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !keys.IsBookmark(item.Key()) {
+				continue
+			}
+			k := string(item.KeyCopy(nil))
+
+			var r Record
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &r)
+			})
+			if err != nil {
+				return err
+			}
+			if r.String("url") == "" {
+				r["url"] = k
+			}
+
+			norm := r.key()
+			if norm == k {
+				continue
+			}
+			moved[k] = r
+
+			target, ok := merged[norm]
+			if !ok {
+				target, err = loadRecord(txn, r.String("url"))
+				if err != nil {
+					return err
+				}
+			}
+			if target == nil {
+				r["aliases"] = mergeAliases(r.Strings("aliases"), r.String("url"), nil)
+				merged[norm] = r
+				continue
+			}
+			more := append([]string{r.String("url")}, r.Strings("aliases")...)
+			target["aliases"] = mergeAliases(target.Strings("aliases"), target.String("url"), more)
+			merged[norm] = target
+		}
+
+		return nil
+	})
+	if err != nil || done {
+		return 0, err
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for k := range moved {
+		err = wb.Delete([]byte(k))
+		if err != nil {
+			return 0, err
+		}
+	}
+	for k, r := range merged {
+		val, err := json.Marshal(&r)
+		if err != nil {
+			return 0, err
+		}
+		err = wb.Set([]byte(k), val)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = wb.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = snapshot.New(db).MigrateKeys(urlnorm.Key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.Update(func(txn *badger.Txn) error {
+		return txn.Set(marker, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+
+	return len(moved), err
+}
@@ -0,0 +1,163 @@
+package dump
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/robots"
+	"github.com/pkg/errors"
+)
+
+const (
+	// planRobotsConcurrency is the number of the hosts robots.txt is fetched from at once by the plan
+	planRobotsConcurrency = 8
+)
+
+type (
+	// Plan is what the run is going to do, it's worked out without fetching anything or writing to the DB.
+	Plan struct {
+		// New are the bookmarks which are not dumped yet
+		New []DumpRequest
+		// Refresh are the dumped bookmarks which are going to be dumped again, either forced or refreshed
+		Refresh []DumpRequest
+		// Skip are the dumped bookmarks which are left as they are
+		Skip []DumpRequest
+		// Unfetchable are the bookmarks which can't be fetched at all, along with the reason
+		Unfetchable []Unfetchable
+		// Filtered is the number of the bookmarks left out by the filter
+		Filtered int
+		// Hosts is the number of the bookmarks to be fetched by the host
+		Hosts map[string]int
+		// RateLimit is the number of the bookmarks of the same host fetched per second
+		RateLimit int
+		// ETA is how long the run takes at least, as the bookmarks of the same host are fetched at the limited rate,
+		// or at the robots.txt crawl delay of the host if it's longer. The time the fetches take is not taken
+		// into account.
+		ETA time.Duration
+	}
+
+	Unfetchable struct {
+		DumpRequest
+		Reason string
+	}
+)
+
+// Plan tells what Run with the options is going to do. Nothing but robots.txt of the hosts is fetched,
+// for their crawl delays, unless robots.txt is ignored.
+func (d *Dump) Plan(ctx context.Context, opts RunOptions) (*Plan, error) {
+	var (
+		reqs     []DumpRequest
+		filtered int
+		err      error
+	)
+	if opts.Resume {
+		_, reqs, err = d.resumed()
+	} else {
+		reqs, filtered, err = d.requests(opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{Filtered: filtered, Hosts: map[string]int{}, RateLimit: DefaultRateLimit}
+	// origins are the URLs the robots.txt of the hosts is looked up by
+	origins := map[string]*url.URL{}
+	for _, r := range reqs {
+		u, err := fetchableURL(r.Href)
+		if err != nil {
+			p.Unfetchable = append(p.Unfetchable, Unfetchable{DumpRequest: r, Reason: err.Error()})
+			continue
+		}
+
+		exists, err := d.Exists(r.Href)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !exists:
+			p.New = append(p.New, r)
+		case r.Force || r.Refresh:
+			p.Refresh = append(p.Refresh, r)
+		default:
+			p.Skip = append(p.Skip, r)
+			continue
+		}
+		p.Hosts[u.Host]++
+		if _, ok := origins[u.Host]; !ok {
+			origins[u.Host] = u
+		}
+	}
+
+	delays, err := d.crawlDelays(ctx, origins)
+	if err != nil {
+		return nil, err
+	}
+	for host, n := range p.Hosts {
+		// the first bookmark of the host is fetched right away
+		eta := time.Duration(n-1) * robots.Interval(DefaultRateLimit, delays[host])
+		if eta > p.ETA {
+			p.ETA = eta
+		}
+	}
+
+	return p, nil
+}
+
+// crawlDelays returns the robots.txt crawl delays of the hosts, robots.txt of a few of them is fetched at once.
+func (d *Dump) crawlDelays(ctx context.Context, origins map[string]*url.URL) (map[string]time.Duration, error) {
+	delays := map[string]time.Duration{}
+	if d.rc == nil {
+		return delays, nil
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		sem      = make(chan struct{}, planRobotsConcurrency)
+	)
+	for host, u := range origins {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(host string, u *url.URL) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			rules, err := d.rc.Rules(ctx, u)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			delays[host] = rules.CrawlDelay()
+		}(host, u)
+	}
+	wg.Wait()
+
+	return delays, firstErr
+}
+
+// fetchableURL parses the URL of the bookmark, making sure it's the one the fetchers could retrieve.
+func fetchableURL(href string) (*url.URL, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return nil, errors.New("bad URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("URL has no host")
+	}
+
+	return u, nil
+}
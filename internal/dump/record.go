@@ -0,0 +1,267 @@
+package dump
+
+import (
+	"encoding/json"
+
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/Neurostep/go-nate/internal/urlnorm"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Record is the dumped bookmark the way it's stored in the DB and then indexed.
+type Record map[string]interface{}
+
+const (
+	_canonicalNamespace = "canonical"
+)
+
+// String returns the value of the string field, an empty string if there is no such.
+func (r Record) String(key string) string {
+	s, _ := r[key].(string)
+	return s
+}
+
+// Strings returns the value of the list of strings field.
+func (r Record) Strings(key string) []string {
+	switch v := r[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+
+	return nil
+}
+
+// Int returns the value of the numeric field, zero if there is no such.
+func (r Record) Int(key string) int64 {
+	switch v := r[key].(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+
+	return 0
+}
+
+// Float returns the value of the numeric field, zero if there is no such.
+func (r Record) Float(key string) float64 {
+	switch v := r[key].(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	}
+
+	return 0
+}
+
+// Bool returns the value of the boolean field, false if there is no such.
+func (r Record) Bool(key string) bool {
+	b, _ := r[key].(bool)
+	return b
+}
+
+// carry returns the copy of the record dumped before, so the fields which aren't fetched again survive the save.
+// It's the empty record if the bookmark is dumped for the first time.
+func carry(prev Record) Record {
+	r := Record{}
+	for k, v := range prev {
+		r[k] = v
+	}
+
+	return r
+}
+
+// keepHealth carries the outcome of the latest check over to the record replacing the checked one, it's
+// the check which updates it.
+func keepHealth(r, prev Record) {
+	for _, k := range []string{"health", "http_status", "checked_url", "checked_at"} {
+		if v, ok := prev[k]; ok {
+			r[k] = v
+		}
+	}
+}
+
+// key is the normalized URL the record is stored under.
+func (r Record) key() string {
+	return urlnorm.Key(r.String("url"))
+}
+
+// resolvedURL is the URL the bookmark actually stands for. Bookmarks having the same one are duplicates.
+func (r Record) resolvedURL() string {
+	for _, k := range []string{"canonical_url", "final_url", "url"} {
+		if u := r.String(k); u != "" {
+			return u
+		}
+	}
+
+	return ""
+}
+
+// updateDuplicates keeps track of the bookmarks resolving to the same page and flags
+// each of them with the list of the others in the "duplicates" field and the "duplicate" one.
+// It's called with the record about to be saved and the one it's replacing, if any.
+func updateDuplicates(txn *badger.Txn, b, prev Record) error {
+	href := b.key()
+	resolved := b.resolvedURL()
+
+	if prev != nil {
+		if old := prev.resolvedURL(); urlnorm.Key(old) != urlnorm.Key(resolved) {
+			err := updateResolved(txn, old, href, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	members, err := resolvedMembers(txn, resolved)
+	if err != nil {
+		return err
+	}
+	b.setDuplicates(append(members, href))
+
+	return updateResolved(txn, resolved, href, true)
+}
+
+// updateResolved adds or removes the bookmark from the set of the ones resolving to the URL,
+// then refreshes the "duplicates" field of the rest of the set.
+func updateResolved(txn *badger.Txn, resolved, href string, add bool) error {
+	members, err := resolvedMembers(txn, resolved)
+	if err != nil {
+		return err
+	}
+
+	var set []string
+	for _, m := range members {
+		if m != href {
+			set = append(set, m)
+		}
+	}
+	if add {
+		set = append(set, href)
+	}
+
+	if len(set) == 0 {
+		return txn.Delete(canonicalKey(resolved))
+	}
+
+	val, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	err = txn.Set(canonicalKey(resolved), val)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range set {
+		if m == href {
+			continue
+		}
+
+		rec, err := loadRecord(txn, m)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			continue
+		}
+
+		rec.setDuplicates(set)
+
+		err = saveRecord(txn, rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setDuplicates flags the record with the rest of the bookmarks of the set.
+func (r Record) setDuplicates(set []string) {
+	href := r.key()
+
+	dups := []string{}
+	for _, m := range set {
+		if m != href {
+			dups = append(dups, m)
+		}
+	}
+
+	r["duplicates"] = dups
+	r["duplicate"] = len(dups) > 0
+}
+
+func resolvedMembers(txn *badger.Txn, resolved string) ([]string, error) {
+	var members []string
+
+	item, err := txn.Get(canonicalKey(resolved))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &members)
+	})
+
+	return members, err
+}
+
+func canonicalKey(resolved string) []byte {
+	return keys.Key(_canonicalNamespace, urlnorm.Key(resolved))
+}
+
+func loadRecord(txn *badger.Txn, href string) (Record, error) {
+	var b Record
+
+	item, err := txn.Get([]byte(urlnorm.Key(href)))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &b)
+	})
+
+	return b, err
+}
+
+// saveRecord stores the record under the normalized URL.
+func saveRecord(txn *badger.Txn, b Record) error {
+	bm, err := json.Marshal(&b)
+	if err != nil {
+		return err
+	}
+
+	href := b.String("url")
+	key := b.key()
+
+	// the bookmark could have been stored under its raw URL before the normalization was introduced
+	if key != href {
+		err = txn.Delete([]byte(href))
+		if err != nil {
+			return err
+		}
+	}
+
+	return txn.Set([]byte(key), bm)
+}
@@ -0,0 +1,88 @@
+package extract
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Canonical looks for the canonical URL of the page, declared either with
+// <link rel="canonical"> or with <meta property="og:url">, the former wins.
+// The URL is resolved against the base, which is the URL the page was retrieved from.
+func Canonical(body []byte, base string) string {
+	var link, og string
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+Loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break Loop
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.DataAtom {
+			case atom.Link:
+				if link == "" && hasToken(attr(t, "rel"), "canonical") {
+					link = attr(t, "href")
+				}
+			case atom.Meta:
+				if og == "" && attr(t, "property") == "og:url" {
+					og = attr(t, "content")
+				}
+			case atom.Body:
+				// both are expected in the head
+				break Loop
+			}
+		}
+	}
+
+	c := link
+	if c == "" {
+		c = og
+	}
+
+	return resolve(base, c)
+}
+
+func attr(t html.Token, name string) string {
+	for _, a := range t.Attr {
+		if a.Key == name {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+
+	return ""
+}
+
+// hasToken reports whether the space separated list contains the token, case-insensitively.
+func hasToken(list, token string) bool {
+	for _, f := range strings.Fields(list) {
+		if strings.EqualFold(f, token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve makes the reference absolute, an empty string is returned for an invalid one.
+func resolve(base, ref string) string {
+	if ref == "" {
+		return ""
+	}
+
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+
+	b, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+
+	return b.ResolveReference(r).String()
+}
@@ -0,0 +1,137 @@
+package extract
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/saintfish/chardet"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+const (
+	UTF8 = "utf-8"
+
+	// _prescanSize is how far the declarations are looked for, the spec says 1024 bytes,
+	// but plenty of pages put a lot of other stuff in the head before them
+	_prescanSize = 4096
+	// _minSniffConfidence is the confidence of the detector the sniffed charset is trusted from
+	_minSniffConfidence = 30
+)
+
+var (
+	_xmlDeclaration = regexp.MustCompile(`^\s*<\?xml[^>]*encoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+
+	// _detectorNames are the names of the detected charsets not known as labels of the WHATWG encodings
+	_detectorNames = map[string]string{
+		"GB-18030": "gb18030",
+	}
+)
+
+// ToUTF8 transcodes the content to UTF-8 and returns it along with the name of the charset it was in.
+// See DetectCharset on how the charset is determined.
+func ToUTF8(body []byte, contentType string) ([]byte, string, error) {
+	e, name := DetectCharset(body, contentType)
+	if name == UTF8 {
+		return bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), name, nil
+	}
+
+	res, _, err := transform.Bytes(e.NewDecoder(), body)
+	if err != nil {
+		return nil, name, errors.Wrapf(err, "couldn't transcode content from %s", name)
+	}
+
+	return res, name, nil
+}
+
+// DetectCharset determines the charset of the content. The Content-Type header and the byte order mark
+// are looked at first, then the declaration in the document, either in <meta> tags or in the XML declaration.
+// If none of them is there, the content is sniffed. Windows-1252 is assumed if nothing helps.
+func DetectCharset(body []byte, contentType string) (encoding.Encoding, string) {
+	if e, name, certain := charset.DetermineEncoding(body, contentType); certain {
+		return e, name
+	}
+
+	head := body
+	if len(head) > _prescanSize {
+		head = head[:_prescanSize]
+	}
+
+	var label string
+	if m := _xmlDeclaration.FindSubmatch(head); m != nil {
+		label = string(m[1])
+	} else {
+		label = metaCharset(head)
+	}
+	if e, name := charset.Lookup(label); e != nil {
+		return e, name
+	}
+
+	if utf8.Valid(body) {
+		return encoding.Nop, UTF8
+	}
+
+	if r, err := chardet.NewHtmlDetector().DetectBest(body); err == nil && r.Confidence >= _minSniffConfidence {
+		label := r.Charset
+		if n, ok := _detectorNames[label]; ok {
+			label = n
+		}
+		if e, name := charset.Lookup(label); e != nil {
+			return e, name
+		}
+	}
+
+	return charmap.Windows1252, "windows-1252"
+}
+
+// metaCharset looks for the charset declared with either <meta charset> or <meta http-equiv="Content-Type">.
+func metaCharset(head []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(head))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.DataAtom {
+			case atom.Body:
+				return ""
+			case atom.Meta:
+				if cs := attr(t, "charset"); cs != "" {
+					return cs
+				}
+				if strings.EqualFold(attr(t, "http-equiv"), "content-type") {
+					if cs := contentCharset(attr(t, "content")); cs != "" {
+						return cs
+					}
+				}
+			}
+		}
+	}
+}
+
+// contentCharset extracts the charset out of the content attribute, e.g. "text/html; charset=windows-1251".
+func contentCharset(content string) string {
+	i := strings.Index(strings.ToLower(content), "charset")
+	if i < 0 {
+		return ""
+	}
+
+	cs := strings.TrimLeft(content[i+len("charset"):], " \t")
+	if !strings.HasPrefix(cs, "=") {
+		return ""
+	}
+	cs = strings.Trim(strings.TrimLeft(cs[1:], " \t"), `"'`)
+	if i := strings.IndexAny(cs, `;"' `); i >= 0 {
+		cs = cs[:i]
+	}
+
+	return cs
+}
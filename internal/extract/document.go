@@ -0,0 +1,104 @@
+package extract
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type (
+	// Document is the content extracted from a non-HTML bookmark.
+	Document struct {
+		Title  string
+		Author string
+		Text   string
+		// HTML is the rendered document, if the format has a natural HTML representation
+		HTML string
+	}
+
+	// Kind is the format of the bookmark content, which defines the way it's extracted.
+	Kind string
+)
+
+const (
+	KindHTML     Kind = "html"
+	KindPDF      Kind = "pdf"
+	KindMarkdown Kind = "markdown"
+	KindText     Kind = "text"
+	KindJSON     Kind = "json"
+	KindXML      Kind = "xml"
+)
+
+var (
+	pdfMagic = []byte("%PDF-")
+
+	_mediaTypes = map[string]Kind{
+		"text/html":             KindHTML,
+		"application/xhtml+xml": KindHTML,
+		"application/pdf":       KindPDF,
+		"text/markdown":         KindMarkdown,
+		"text/x-markdown":       KindMarkdown,
+		"text/plain":            KindText,
+		"application/json":      KindJSON,
+		"text/json":             KindJSON,
+		"application/xml":       KindXML,
+		"text/xml":              KindXML,
+	}
+
+	_extensions = map[string]Kind{
+		".md":       KindMarkdown,
+		".markdown": KindMarkdown,
+		".txt":      KindText,
+		".json":     KindJSON,
+		".xml":      KindXML,
+	}
+)
+
+// Detect tells the format of the content by its Content-Type. Raw files are often served as
+// text/plain or application/octet-stream whatever they are, so for these the extension of the URL
+// decides, and the content is sniffed when neither of them helps. HTML is assumed by default.
+func Detect(contentType, href string, body []byte) Kind {
+	if bytes.HasPrefix(bytes.TrimLeft(body, " \t\r\n"), pdfMagic) {
+		return KindPDF
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt = ""
+	}
+
+	generic := mt == "" || mt == "text/plain" || mt == "application/octet-stream"
+	if generic {
+		if k, ok := _extensions[extension(href)]; ok {
+			return k
+		}
+	}
+	if mt == "" || mt == "application/octet-stream" {
+		mt, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+
+	if k, ok := _mediaTypes[mt]; ok {
+		return k
+	}
+
+	switch {
+	case strings.HasSuffix(mt, "+json"):
+		return KindJSON
+	case strings.HasSuffix(mt, "+xml"):
+		return KindXML
+	}
+
+	return KindHTML
+}
+
+func extension(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(path.Ext(u.Path))
+}
@@ -0,0 +1,46 @@
+package extract
+
+import (
+	"bytes"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Icons returns the icons the page declares with <link rel="icon">, the "shortcut icon" included,
+// followed by the apple-touch-icon ones, which are larger but still fine as the last resort.
+// The URLs are resolved against the base, which is the URL the page was retrieved from.
+func Icons(body []byte, base string) []string {
+	var icons, touch []string
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+Loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break Loop
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.DataAtom {
+			case atom.Link:
+				href := resolve(base, attr(t, "href"))
+				if href == "" {
+					continue
+				}
+
+				rel := attr(t, "rel")
+				switch {
+				case hasToken(rel, "icon"):
+					icons = append(icons, href)
+				case hasToken(rel, "apple-touch-icon"), hasToken(rel, "apple-touch-icon-precomposed"):
+					touch = append(touch, href)
+				}
+			case atom.Body:
+				// icons are expected in the head
+				break Loop
+			}
+		}
+	}
+
+	return append(icons, touch...)
+}
@@ -0,0 +1,41 @@
+package extract
+
+import (
+	"bytes"
+	"net/url"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Links returns the outbound links of the page, the http and https ones, in the order they first appear.
+// The URLs are resolved against the base, which is the URL the page was retrieved from, their fragments are dropped.
+func Links(body []byte, base string) []string {
+	var res []string
+	seen := map[string]bool{}
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return res
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			if t.DataAtom != atom.A && t.DataAtom != atom.Area {
+				continue
+			}
+
+			u, err := url.Parse(resolve(base, attr(t, "href")))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				continue
+			}
+			u.Fragment = ""
+
+			href := u.String()
+			if !seen[href] {
+				seen[href] = true
+				res = append(res, href)
+			}
+		}
+	}
+}
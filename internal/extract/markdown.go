@@ -0,0 +1,103 @@
+package extract
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// Markdown renders the document to HTML and to plain text. The title is the text of the first
+// top level heading, or of the first heading of any level if there are no top level ones.
+func Markdown(body []byte) (*Document, error) {
+	md := goldmark.New()
+	root := md.Parser().Parse(text.NewReader(body))
+
+	var html bytes.Buffer
+	err := md.Renderer().Render(&html, body, root)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't render markdown")
+	}
+
+	var (
+		sb    strings.Builder
+		title string
+		level int
+	)
+	err = ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch n := n.(type) {
+		case *ast.Heading:
+			if entering && (title == "" || n.Level < level) {
+				if t := strings.TrimSpace(string(n.Text(body))); t != "" {
+					title, level = t, n.Level
+				}
+			}
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			if entering {
+				lines := n.Lines()
+				for i := 0; i < lines.Len(); i++ {
+					seg := lines.At(i)
+					sb.Write(seg.Value(body))
+				}
+			}
+		case *ast.Text:
+			if entering {
+				sb.Write(n.Segment.Value(body))
+				if n.SoftLineBreak() || n.HardLineBreak() {
+					sb.WriteByte('\n')
+				}
+			}
+		case *ast.String:
+			if entering {
+				sb.Write(n.Value)
+			}
+		case *ast.AutoLink:
+			if entering {
+				sb.Write(n.Label(body))
+			}
+		case *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		}
+
+		// blocks are separated by an empty line, like paragraphs of the source
+		if !entering && n.Type() == ast.TypeBlock && n.Kind() != ast.KindList && n.Kind() != ast.KindListItem {
+			sb.WriteString("\n\n")
+		}
+
+		return ast.WalkContinue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{
+		Title: title,
+		Text:  squeezeBlankLines(sb.String()),
+		HTML:  html.String(),
+	}, nil
+}
+
+// squeezeBlankLines trims the text and leaves at most one empty line between the lines of it.
+func squeezeBlankLines(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	res := make([]string, 0, len(lines))
+
+	blank := false
+	for _, l := range lines {
+		l = strings.TrimRight(l, " \t\r")
+		if l == "" {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		res = append(res, l)
+	}
+
+	return strings.Join(res, "\n")
+}
@@ -0,0 +1,363 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type (
+	// Metadata is what the page tells about itself in the <meta> tags and the schema.org JSON-LD.
+	Metadata struct {
+		Description string
+		Keywords    []string
+		// OpenGraph and Twitter are the og:* and twitter:* properties by their names without the prefix,
+		// e.g. "title" or "image:alt". The first value of the repeated property is kept.
+		OpenGraph map[string]string
+		Twitter   map[string]string
+		// PublishedTime and ModifiedTime are either the article:* properties or the dates of JSON-LD, in RFC 3339
+		PublishedTime, ModifiedTime string
+		// Section and Tags are the article:section and article:tag properties
+		Section string
+		Tags    []string
+		// SchemaType and SchemaAuthors are the type and the authors of the main entity of JSON-LD
+		SchemaType    string
+		SchemaAuthors []string
+	}
+
+	// jsonLDEntity is the schema.org entity, e.g. {"@type": "Article", "author": {...}, ...}
+	jsonLDEntity map[string]interface{}
+)
+
+const (
+	_ogPrefix      = "og:"
+	_twitterPrefix = "twitter:"
+	_articlePrefix = "article:"
+	_jsonLDType    = "application/ld+json"
+)
+
+var (
+	// _dateLayouts are the layouts of the dates seen in the wild, besides RFC 3339
+	_dateLayouts = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05Z0700",
+		"2006-01-02T15:04:05.000Z0700",
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+		time.RFC1123Z,
+		time.RFC1123,
+	}
+
+	// _mainEntityTypes are the schema.org types the page is about, rather than the ones describing its parts,
+	// like BreadcrumbList or WebSite
+	_mainEntityTypes = map[string]bool{
+		"Article": true, "NewsArticle": true, "BlogPosting": true, "TechArticle": true, "ScholarlyArticle": true,
+		"Report": true, "Review": true, "Recipe": true, "HowTo": true, "Book": true, "Movie": true, "Product": true,
+		"Course": true, "Event": true, "SoftwareApplication": true, "SoftwareSourceCode": true, "Dataset": true,
+		"VideoObject": true, "PodcastEpisode": true, "QAPage": true, "FAQPage": true, "ProfilePage": true,
+	}
+)
+
+// ParseMetadata parses the <meta> tags and the JSON-LD scripts of the page, the body is expected to be in UTF-8.
+func ParseMetadata(body []byte) Metadata {
+	m := Metadata{OpenGraph: map[string]string{}, Twitter: map[string]string{}}
+
+	var (
+		inJSONLD       bool
+		entities       []jsonLDEntity
+		published, mod string
+	)
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			m.finish(entities, published, mod)
+			return m
+		case html.TextToken:
+			if inJSONLD {
+				entities = append(entities, parseJSONLD(z.Text())...)
+			}
+		case html.EndTagToken:
+			inJSONLD = false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.DataAtom {
+			case atom.Script:
+				inJSONLD = tt == html.StartTagToken && strings.EqualFold(attr(t, "type"), _jsonLDType)
+			case atom.Meta:
+				// OpenGraph uses property, but plenty of pages put it into name as well
+				key := strings.ToLower(attr(t, "property"))
+				if key == "" {
+					key = strings.ToLower(attr(t, "name"))
+				}
+				content := attr(t, "content")
+				if key == "" || content == "" {
+					continue
+				}
+
+				switch {
+				case key == "description":
+					if m.Description == "" {
+						m.Description = content
+					}
+				case key == "keywords":
+					m.Keywords = appendUniq(m.Keywords, splitList(content)...)
+				case strings.HasPrefix(key, _ogPrefix):
+					setFirst(m.OpenGraph, strings.TrimPrefix(key, _ogPrefix), content)
+				case strings.HasPrefix(key, _twitterPrefix):
+					setFirst(m.Twitter, strings.TrimPrefix(key, _twitterPrefix), content)
+				case key == _articlePrefix+"published_time":
+					if published == "" {
+						published = content
+					}
+				case key == _articlePrefix+"modified_time":
+					if mod == "" {
+						mod = content
+					}
+				case key == _articlePrefix+"section":
+					if m.Section == "" {
+						m.Section = content
+					}
+				case key == _articlePrefix+"tag":
+					m.Tags = appendUniq(m.Tags, splitList(content)...)
+				}
+			}
+		}
+	}
+}
+
+// Fields returns the metadata as the fields of the record, the empty ones are omitted.
+// The og:* and twitter:* properties are prefixed with "og_" and "twitter_", with the colons replaced by underscores.
+func (m Metadata) Fields() map[string]interface{} {
+	f := map[string]interface{}{}
+
+	set := func(k string, v interface{}) {
+		switch v := v.(type) {
+		case string:
+			if v == "" {
+				return
+			}
+		case []string:
+			if len(v) == 0 {
+				return
+			}
+		}
+		f[k] = v
+	}
+
+	set("description", m.Description)
+	set("keywords", m.Keywords)
+	set("published_at", m.PublishedTime)
+	set("modified_at", m.ModifiedTime)
+	set("section", m.Section)
+	set("tags", m.Tags)
+	set("schema_type", m.SchemaType)
+	set("schema_authors", m.SchemaAuthors)
+	for k, v := range m.OpenGraph {
+		set("og_"+fieldName(k), v)
+	}
+	for k, v := range m.Twitter {
+		set("twitter_"+fieldName(k), v)
+	}
+
+	return f
+}
+
+// Image returns the URL of the preview image of the page, either the OpenGraph or the Twitter card one,
+// resolved against the base, which is the URL the page was retrieved from.
+func (m Metadata) Image(base string) string {
+	for _, href := range []string{
+		m.OpenGraph["image:secure_url"], m.OpenGraph["image"], m.OpenGraph["image:url"],
+		m.Twitter["image"], m.Twitter["image:src"],
+	} {
+		if href != "" {
+			return resolve(base, href)
+		}
+	}
+
+	return ""
+}
+
+// finish picks the main entity of JSON-LD and settles the dates, the ones of the meta tags win.
+func (m *Metadata) finish(entities []jsonLDEntity, published, mod string) {
+	var main jsonLDEntity
+	for _, e := range entities {
+		types := stringsOf(e["@type"])
+		if len(types) == 0 {
+			continue
+		}
+		if main == nil {
+			main = e
+		}
+		if isMainEntity(types) {
+			main = e
+			break
+		}
+	}
+
+	if main != nil {
+		m.SchemaType = stringsOf(main["@type"])[0]
+		m.SchemaAuthors = appendUniq(names(main["author"]), names(main["creator"])...)
+		for _, k := range []string{"datePublished", "dateCreated"} {
+			if published == "" {
+				published = firstString(main[k])
+			}
+		}
+		if mod == "" {
+			mod = firstString(main["dateModified"])
+		}
+	}
+
+	m.PublishedTime = normalizeDate(published)
+	m.ModifiedTime = normalizeDate(mod)
+}
+
+// parseJSONLD returns the entities of the script, which holds either one of them, the list or the graph of them.
+// The script which is not valid JSON is ignored.
+func parseJSONLD(script []byte) []jsonLDEntity {
+	var v interface{}
+	if json.Unmarshal(bytes.TrimSpace(script), &v) != nil {
+		return nil
+	}
+
+	return entitiesOf(v)
+}
+
+func entitiesOf(v interface{}) []jsonLDEntity {
+	var res []jsonLDEntity
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res = append(res, v)
+		res = append(res, entitiesOf(v["@graph"])...)
+	case []interface{}:
+		for _, e := range v {
+			res = append(res, entitiesOf(e)...)
+		}
+	}
+
+	return res
+}
+
+func isMainEntity(types []string) bool {
+	for _, t := range types {
+		if _mainEntityTypes[strings.TrimPrefix(t, "schema:")] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// names returns the names of the authors, which are either the strings or the Person and Organization objects.
+func names(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		if s := strings.TrimSpace(v); s != "" {
+			return []string{s}
+		}
+	case map[string]interface{}:
+		if n, ok := v["name"].(string); ok && strings.TrimSpace(n) != "" {
+			return []string{strings.TrimSpace(n)}
+		}
+	case []interface{}:
+		var res []string
+		for _, a := range v {
+			res = append(res, names(a)...)
+		}
+		return res
+	}
+
+	return nil
+}
+
+// stringsOf returns the value which is either a string or the list of them as the list.
+func stringsOf(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		var res []string
+		for _, s := range v {
+			if s, ok := s.(string); ok && s != "" {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+
+	return nil
+}
+
+func firstString(v interface{}) string {
+	if ss := stringsOf(v); len(ss) > 0 {
+		return ss[0]
+	}
+
+	return ""
+}
+
+// normalizeDate converts the date into RFC 3339, so it's indexed as the date. An empty string is returned
+// if the date isn't recognized.
+func normalizeDate(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+
+	for _, l := range _dateLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return ""
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, k := range strings.Split(s, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			res = append(res, k)
+		}
+	}
+
+	return res
+}
+
+func appendUniq(list []string, more ...string) []string {
+	for _, s := range more {
+		found := false
+		for _, l := range list {
+			if strings.EqualFold(l, s) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+func setFirst(m map[string]string, k, v string) {
+	if _, ok := m[k]; !ok {
+		m[k] = v
+	}
+}
+
+func fieldName(property string) string {
+	return strings.NewReplacer(":", "_", ".", "_", "-", "_").Replace(property)
+}
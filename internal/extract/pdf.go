@@ -0,0 +1,60 @@
+package extract
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/pkg/errors"
+)
+
+// PDF extracts the text of the PDF document page by page, along with the title
+// and the author from its document information dictionary.
+func PDF(body []byte) (doc *Document, err error) {
+	// the parser panics on some malformed documents instead of returning an error
+	defer func() {
+		if x := recover(); x != nil {
+			doc, err = nil, errors.Errorf("malformed PDF: %v", x)
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read PDF")
+	}
+
+	info := r.Trailer().Key("Info")
+	doc = &Document{
+		Title:  strings.TrimSpace(info.Key("Title").Text()),
+		Author: strings.TrimSpace(info.Key("Author").Text()),
+	}
+
+	var (
+		pages = make([]string, 0, r.NumPage())
+		fonts = make(map[string]*pdf.Font)
+	)
+	for i := 1; i <= r.NumPage(); i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+		// fonts are shared between the pages, so their char maps are parsed once
+		for _, name := range p.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				f := p.Font(name)
+				fonts[name] = &f
+			}
+		}
+
+		text, err := p.GetPlainText(fonts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't extract text of page %d", i)
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			pages = append(pages, text)
+		}
+	}
+	doc.Text = strings.Join(pages, "\n\n")
+
+	return doc, nil
+}
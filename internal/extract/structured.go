@@ -0,0 +1,136 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+const (
+	_indent = "  "
+)
+
+// PlainText keeps the text as it is.
+func PlainText(body []byte) *Document {
+	return &Document{Text: string(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")))}
+}
+
+// JSON pretty-prints the document, which is kept as it is if it's not a valid JSON.
+func JSON(body []byte) *Document {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, bytes.TrimSpace(body), "", _indent); err != nil {
+		return PlainText(body)
+	}
+
+	return &Document{Text: buf.String()}
+}
+
+// XML pretty-prints the document, which is kept as it is if it's not a well-formed XML.
+// The title is the text of the first <title> element, as in RSS and Atom feeds.
+// The document is expected to be transcoded to UTF-8 already, whatever its declaration says.
+func XML(body []byte) *Document {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var toks []xml.Token
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return PlainText(body)
+		}
+		toks = append(toks, xml.CopyToken(t))
+	}
+
+	var (
+		sb    strings.Builder
+		title string
+		depth int
+	)
+	indent := func() {
+		sb.WriteString(strings.Repeat(_indent, depth))
+	}
+
+	for i := 0; i < len(toks); i++ {
+		switch t := toks[i].(type) {
+		case xml.StartElement:
+			indent()
+			sb.WriteString("<" + qname(t.Name))
+			for _, a := range t.Attr {
+				sb.WriteString(" " + qname(a.Name) + `="`)
+				_ = xml.EscapeText(&sb, []byte(a.Value))
+				sb.WriteString(`"`)
+			}
+			sb.WriteString(">")
+
+			// elements holding nothing but text stay on a single line
+			j, text := i+1, ""
+			if cd, ok := tokenAt(toks, j).(xml.CharData); ok {
+				text = strings.TrimSpace(string(cd))
+				j++
+			}
+			if _, ok := tokenAt(toks, j).(xml.EndElement); ok {
+				_ = xml.EscapeText(&sb, []byte(text))
+				sb.WriteString("</" + qname(t.Name) + ">\n")
+				if title == "" && t.Name.Local == "title" {
+					title = text
+				}
+				i = j
+				continue
+			}
+
+			sb.WriteString("\n")
+			depth++
+		case xml.EndElement:
+			if depth > 0 {
+				depth--
+			}
+			indent()
+			sb.WriteString("</" + qname(t.Name) + ">\n")
+		case xml.CharData:
+			if text := strings.TrimSpace(string(t)); text != "" {
+				indent()
+				_ = xml.EscapeText(&sb, []byte(text))
+				sb.WriteString("\n")
+			}
+		case xml.Comment:
+			indent()
+			sb.WriteString("<!--" + string(t) + "-->\n")
+		case xml.ProcInst:
+			// the declaration is dropped, as its encoding is not the one of the text anymore
+			if t.Target == "xml" {
+				continue
+			}
+			indent()
+			sb.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>\n")
+		case xml.Directive:
+			indent()
+			sb.WriteString("<!" + string(t) + ">\n")
+		}
+	}
+
+	return &Document{Title: title, Text: strings.TrimSpace(sb.String())}
+}
+
+func tokenAt(toks []xml.Token, i int) xml.Token {
+	if i < len(toks) {
+		return toks[i]
+	}
+
+	return nil
+}
+
+// qname formats the name the way it's written in the document, the prefix isn't resolved by RawToken.
+func qname(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+
+	return n.Space + ":" + n.Local
+}
@@ -0,0 +1,119 @@
+package failed
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/Neurostep/go-nate/internal/urlnorm"
+	"github.com/dgraph-io/badger/v3"
+)
+
+type (
+	// Failure is the bookmark which couldn't be dumped, along with the reason of the latest failure.
+	Failure struct {
+		URL    string `json:"url"`
+		Folder string `json:"folder,omitempty"`
+		Title  string `json:"title,omitempty"`
+		Error  string `json:"error"`
+		// Attempts is the number of times dumping the bookmark has failed in a row
+		Attempts      int    `json:"attempts"`
+		FirstFailedAt string `json:"first_failed_at"`
+		LastTriedAt   string `json:"last_tried_at"`
+	}
+
+	Store struct {
+		db *badger.DB
+	}
+)
+
+const (
+	_namespace = "failed"
+)
+
+func New(db *badger.DB) *Store {
+	return &Store{db: db}
+}
+
+// Add records the failure of the bookmark, counting it along with the previous ones.
+func (s *Store) Add(f Failure) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	key := keys.Key(_namespace, urlnorm.Key(f.URL))
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		var prev Failure
+		item, err := txn.Get(key)
+		switch err {
+		case nil:
+			err = item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &prev)
+			})
+			if err != nil {
+				return err
+			}
+		case badger.ErrKeyNotFound:
+			prev.FirstFailedAt = now
+		default:
+			return err
+		}
+
+		f.Attempts = prev.Attempts + 1
+		f.FirstFailedAt = prev.FirstFailedAt
+		f.LastTriedAt = now
+
+		val, err := json.Marshal(&f)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(key, val)
+	})
+}
+
+// Remove forgets the failures of the bookmark, once it's dumped successfully.
+func (s *Store) Remove(href string) error {
+	key := keys.Key(_namespace, urlnorm.Key(href))
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return txn.Delete(key)
+	})
+}
+
+// List returns the failed bookmarks, the most recently tried first.
+func (s *Store) List() ([]Failure, error) {
+	var res []Failure
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		prefix := keys.Prefix(_namespace)
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var f Failure
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &f)
+			})
+			if err != nil {
+				return err
+			}
+			res = append(res, f)
+		}
+
+		return nil
+	})
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].LastTriedAt > res[j].LastTriedAt
+	})
+
+	return res, err
+}
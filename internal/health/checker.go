@@ -0,0 +1,246 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/Neurostep/go-nate/internal/logger"
+	"github.com/Neurostep/go-nate/internal/pool"
+	"github.com/Neurostep/go-nate/internal/robots"
+	"github.com/dgraph-io/badger/v3"
+)
+
+type (
+	Props struct {
+		Logger   *logger.Logger
+		PoolSize int
+		Fetcher  dl.Fetcher
+		Db       *badger.DB
+		// Robots is the same crawl policy the dump follows, the disallowed bookmarks are not visited and the hosts
+		// are visited no more often than their crawl delays let. Nil ignores robots.txt.
+		Robots *robots.Cache
+		// Timeout limits the time spent on a single bookmark
+		Timeout time.Duration
+	}
+
+	Checker struct {
+		p  *pool.Pool
+		l  *logger.Logger
+		f  dl.Fetcher
+		db *badger.DB
+		rc *robots.Cache
+
+		timeout time.Duration
+	}
+
+	Report struct {
+		// Key is the normalized URL the bookmark is stored under
+		Key        string  `json:"-"`
+		URL        string  `json:"url"`
+		Health     Outcome `json:"health"`
+		HttpStatus int     `json:"http_status,omitempty"`
+		// FinalURL is the URL the check has ended up at, it's kept as checked_url apart from the one of the dump
+		FinalURL  string `json:"final_url,omitempty"`
+		Error     string `json:"error,omitempty"`
+		CheckedAt string `json:"checked_at"`
+	}
+)
+
+const (
+	defaultRateLimit = 2
+	defaultTimeout   = time.Second * 30
+)
+
+func New(props Props) *Checker {
+	timeout := props.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Checker{
+		p:       pool.NewPool(props.PoolSize),
+		l:       props.Logger,
+		f:       props.Fetcher,
+		db:      props.Db,
+		rc:      props.Robots,
+		timeout: timeout,
+	}
+}
+
+// Run visits every dumped bookmark, stores the outcome in its record and returns
+// the reports sorted by health and URL.
+func (c *Checker) Run(ctx context.Context) ([]Report, error) {
+	bms, err := c.bookmarks()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reports  []Report
+		limiters = robots.NewHostLimiters(c.rc, defaultRateLimit)
+	)
+
+	for key, href := range bms {
+		if ctx.Err() != nil {
+			break
+		}
+
+		u, err := url.Parse(href)
+		if err != nil {
+			c.l.Errorf("couldn't parse URL: %s", href)
+			continue
+		}
+
+		wg.Add(1)
+		func(key, href string) {
+			c.p.Schedule(func() {
+				defer wg.Done()
+
+				// interrupted while waiting for the host, the bookmark isn't checked
+				if limiters.Take(ctx, u) != nil {
+					return
+				}
+
+				r := c.Check(ctx, href)
+				r.Key = key
+				// interrupted check tells nothing about the bookmark
+				if ctx.Err() != nil {
+					return
+				}
+
+				err := c.save(r)
+				if err != nil {
+					c.l.Errorf("couldn't save health of bookmark %s: %s", href, err)
+				}
+
+				mu.Lock()
+				reports = append(reports, r)
+				mu.Unlock()
+			})
+		}(key, href)
+	}
+
+	wg.Wait()
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Health != reports[j].Health {
+			return reports[i].Health < reports[j].Health
+		}
+		return reports[i].URL < reports[j].URL
+	})
+
+	return reports, ctx.Err()
+}
+
+// Check visits the bookmark and classifies the outcome.
+func (c *Checker) Check(ctx context.Context, href string) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	if c.rc != nil {
+		allowed, err := c.rc.Allowed(ctx, href)
+		if err == nil && !allowed {
+			c.l.Debugf("bookmark is disallowed by robots.txt, HREF: %s", href)
+			return Report{URL: href, Health: Disallowed, CheckedAt: time.Now().UTC().Format(time.RFC3339)}
+		}
+	}
+
+	res, err := c.f.Fetch(ctx, href)
+
+	r := Report{
+		URL:       href,
+		Health:    Classify(href, res, err),
+		CheckedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if res != nil {
+		r.HttpStatus = res.StatusCode
+		r.FinalURL = res.FinalURL
+	}
+
+	c.l.Debugf("checked bookmark %s: %s", href, r.Health)
+
+	return r
+}
+
+// bookmarks returns the URLs of the dumped bookmarks by their keys.
+func (c *Checker) bookmarks() (map[string]string, error) {
+	bms := map[string]string{}
+
+	err := c.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !keys.IsBookmark(item.Key()) {
+				continue
+			}
+
+			var doc struct {
+				URL string `json:"url"`
+			}
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &doc)
+			})
+			if err != nil {
+				return err
+			}
+
+			key := string(item.KeyCopy(nil))
+			if doc.URL == "" {
+				doc.URL = key
+			}
+			bms[key] = doc.URL
+		}
+
+		return nil
+	})
+
+	return bms, err
+}
+
+// save puts the outcome of the check into the bookmark record, so it's indexed along with the content. The fields
+// of the dump, e.g. final_url the duplicates are resolved by, are left as they are, so the record doesn't have
+// to go through the dump to be saved.
+func (c *Checker) save(r Report) error {
+	return c.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(r.Key))
+		if err != nil {
+			return err
+		}
+
+		var doc map[string]interface{}
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &doc)
+		})
+		if err != nil {
+			return err
+		}
+
+		doc["health"] = string(r.Health)
+		doc["http_status"] = ""
+		if r.HttpStatus != 0 {
+			doc["http_status"] = strconv.Itoa(r.HttpStatus)
+		}
+		doc["checked_url"] = r.FinalURL
+		doc["checked_at"] = r.CheckedAt
+
+		val, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set([]byte(r.Key), val)
+	})
+}
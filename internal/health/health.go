@@ -0,0 +1,104 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+	"github.com/pkg/errors"
+)
+
+// Outcome is the class of the result of visiting the bookmark.
+type Outcome string
+
+const (
+	OK          Outcome = "ok"
+	DNSFailure  Outcome = "dns_failure"
+	TLSError    Outcome = "tls_error"
+	Timeout     Outcome = "timeout"
+	NotFound    Outcome = "not_found"
+	ClientError Outcome = "client_error"
+	ServerError Outcome = "server_error"
+	Redirected  Outcome = "redirected"
+	// Unreachable covers the rest of the network errors, e.g. refused connection
+	Unreachable Outcome = "unreachable"
+	// Disallowed is the bookmark robots.txt doesn't let to visit, so it's not checked
+	Disallowed Outcome = "disallowed"
+)
+
+// Dead reports whether the bookmark could be considered as a dead link.
+func (o Outcome) Dead() bool {
+	switch o {
+	case OK, Redirected, Disallowed:
+		return false
+	}
+
+	return true
+}
+
+// Classify maps the result of fetching the bookmark to the Outcome.
+func Classify(href string, res *dl.Result, err error) Outcome {
+	if err != nil {
+		return classifyError(err)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return NotFound
+	case res.StatusCode >= http.StatusInternalServerError:
+		return ServerError
+	case res.StatusCode >= http.StatusBadRequest:
+		return ClientError
+	case movedElsewhere(href, res.FinalURL):
+		return Redirected
+	}
+
+	return OK
+}
+
+func classifyError(err error) Outcome {
+	var (
+		dnsErr     *net.DNSError
+		netErr     net.Error
+		authErr    x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+		recordErr  tls.RecordHeaderError
+	)
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return DNSFailure
+	case errors.As(err, &authErr), errors.As(err, &hostErr), errors.As(err, &invalidErr), errors.As(err, &recordErr),
+		strings.Contains(err.Error(), "tls: "):
+		return TLSError
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	}
+
+	return Unreachable
+}
+
+// movedElsewhere reports whether the final URL is on the other host than the requested one.
+// Redirects within the same site, e.g. from http to https or to the "www." subdomain, don't count.
+func movedElsewhere(href, final string) bool {
+	if final == "" {
+		return false
+	}
+
+	from, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	to, err := url.Parse(final)
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimPrefix(strings.ToLower(from.Hostname()), "www.") != strings.TrimPrefix(strings.ToLower(to.Hostname()), "www.")
+}
@@ -0,0 +1,145 @@
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+	"github.com/pkg/errors"
+)
+
+type (
+	Props struct {
+		// Fetcher downloads the images, its body size limit is expected to be at least MaxImageSize
+		Fetcher dl.Fetcher
+		Store   *Store
+		// MaxImageSize and MaxFaviconSize are the sizes of the preview image and the favicon beyond which
+		// they are not kept, DefaultMaxImageSize and DefaultMaxFaviconSize are used if they are zero
+		MaxImageSize   int64
+		MaxFaviconSize int64
+	}
+
+	// Capturer downloads the favicons of the hosts and the preview images of the pages. The favicon is downloaded
+	// once per host, the image once per URL, and either of them is stored once per its content.
+	Capturer struct {
+		f              dl.Fetcher
+		s              *Store
+		maxImageSize   int64
+		maxFaviconSize int64
+
+		// hosts and urls are the downloads made by the capturer, by the host and the image URL,
+		// so the pages sharing them wait for the one download
+		hosts sync.Map
+		urls  sync.Map
+	}
+
+	download struct {
+		once sync.Once
+		id   string
+		err  error
+	}
+)
+
+const (
+	DefaultMaxImageSize   = 1 << 20
+	DefaultMaxFaviconSize = 100 << 10
+
+	_faviconPath = "/favicon.ico"
+)
+
+func New(props Props) *Capturer {
+	c := &Capturer{
+		f:              props.Fetcher,
+		s:              props.Store,
+		maxImageSize:   props.MaxImageSize,
+		maxFaviconSize: props.MaxFaviconSize,
+	}
+	if c.maxImageSize <= 0 {
+		c.maxImageSize = DefaultMaxImageSize
+	}
+	if c.maxFaviconSize <= 0 {
+		c.maxFaviconSize = DefaultMaxFaviconSize
+	}
+
+	return c
+}
+
+// Favicon returns the ID of the favicon of the page host. The icons the page declares are tried first,
+// then /favicon.ico of the host. The favicon stored before is reused, unless refresh is set.
+// Empty ID is returned if the host has no favicon.
+func (c *Capturer) Favicon(ctx context.Context, pageURL string, declared []string, refresh bool) (string, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	host := strings.ToLower(u.Hostname())
+
+	v, _ := c.hosts.LoadOrStore(host, &download{})
+	d := v.(*download)
+	d.once.Do(func() {
+		if !refresh {
+			d.id, d.err = c.s.Favicon(host)
+			if d.err != nil || d.id != "" {
+				return
+			}
+		}
+
+		root := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: _faviconPath}).String()
+		for _, href := range append(declared, root) {
+			d.id, d.err = c.download(ctx, href, c.maxFaviconSize)
+			if d.err == nil {
+				break
+			}
+		}
+		if d.err != nil {
+			return
+		}
+
+		d.err = c.s.SetFavicon(host, d.id)
+	})
+
+	return d.id, d.err
+}
+
+// Image returns the ID of the image downloaded from the URL.
+func (c *Capturer) Image(ctx context.Context, href string) (string, error) {
+	v, _ := c.urls.LoadOrStore(href, &download{})
+	d := v.(*download)
+	d.once.Do(func() {
+		d.id, d.err = c.download(ctx, href, c.maxImageSize)
+	})
+
+	return d.id, d.err
+}
+
+// download fetches the image and stores it under the hash of its content, which is its ID.
+func (c *Capturer) download(ctx context.Context, href string, maxSize int64) (string, error) {
+	res, err := c.f.Fetch(ctx, href)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("image %s responded with %d", href, res.StatusCode)
+	}
+	if res.TooLarge || int64(len(res.Body)) > maxSize {
+		return "", errors.Errorf("image %s is larger than %d bytes", href, maxSize)
+	}
+	if len(res.Body) == 0 {
+		return "", errors.Errorf("image %s is empty", href)
+	}
+
+	ct := res.ContentType()
+	if !strings.HasPrefix(ct, "image/") {
+		return "", errors.Errorf("image %s is %s", href, ct)
+	}
+
+	sum := sha256.Sum256(res.Body)
+	id := hex.EncodeToString(sum[:])
+
+	return id, c.s.Put(id, Image{ContentType: ct, Data: res.Body, URL: href})
+}
@@ -0,0 +1,193 @@
+package images
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Image is the favicon or the preview image, stored once per its content.
+	Image struct {
+		ContentType string `json:"content_type"`
+		Data        []byte `json:"data"`
+		// URL is the one the image was first downloaded from
+		URL string `json:"url"`
+	}
+
+	Store struct {
+		db *badger.DB
+	}
+
+	// Reader reads the images out of the DB, which is opened read-only as long as the images are being requested
+	// and closed once they aren't, so the server showing the images doesn't keep the dump from running.
+	Reader struct {
+		mux   sync.Mutex
+		open  func() (*badger.DB, error)
+		idle  time.Duration
+		db    *badger.DB
+		refs  int
+		timer *time.Timer
+	}
+)
+
+const (
+	_imageNamespace   = "image"
+	_faviconNamespace = "favicon"
+)
+
+var (
+	ErrNoImage = errors.New("image not found")
+)
+
+func NewStore(db *badger.DB) *Store {
+	return &Store{db: db}
+}
+
+// Put stores the image under its ID, the image stored before with the same content is kept as it is.
+func (s *Store) Put(id string, img Image) error {
+	val, err := json.Marshal(&img)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(keys.Key(_imageNamespace, id))
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		return txn.Set(keys.Key(_imageNamespace, id), val)
+	})
+}
+
+// Get returns the image by its ID, ErrNoImage if there is none.
+func (s *Store) Get(id string) (*Image, error) {
+	var img Image
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keys.Key(_imageNamespace, id))
+		if err == badger.ErrKeyNotFound {
+			return ErrNoImage
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &img)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &img, nil
+}
+
+// Favicon returns the ID of the favicon of the host, empty string if it's not known.
+func (s *Store) Favicon(host string) (string, error) {
+	var id string
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keys.Key(_faviconNamespace, host))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			id = string(val)
+			return nil
+		})
+	})
+
+	return id, err
+}
+
+// SetFavicon makes the image the favicon of the host.
+func (s *Store) SetFavicon(host, id string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(keys.Key(_faviconNamespace, host), []byte(id))
+	})
+}
+
+// NewReader returns the reader opening the DB with the function, and closing it once no image is requested
+// for the idle duration.
+func NewReader(open func() (*badger.DB, error), idle time.Duration) *Reader {
+	return &Reader{open: open, idle: idle}
+}
+
+// Get returns the image by its ID, ErrNoImage if there is none.
+func (r *Reader) Get(id string) (*Image, error) {
+	db, err := r.acquire()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open DB")
+	}
+	defer r.release()
+
+	return NewStore(db).Get(id)
+}
+
+// Close closes the DB if it's open.
+func (r *Reader) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+
+	return r.closeDB()
+}
+
+func (r *Reader) acquire() (*badger.DB, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.db == nil {
+		db, err := r.open()
+		if err != nil {
+			return nil, err
+		}
+		r.db = db
+	}
+	r.refs++
+
+	return r.db, nil
+}
+
+func (r *Reader) release() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.refs--
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(r.idle, func() {
+		r.mux.Lock()
+		defer r.mux.Unlock()
+
+		if r.refs == 0 {
+			_ = r.closeDB()
+		}
+	})
+}
+
+func (r *Reader) closeDB() error {
+	if r.db == nil {
+		return nil
+	}
+
+	err := r.db.Close()
+	r.db = nil
+
+	return err
+}
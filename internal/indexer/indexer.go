@@ -2,7 +2,11 @@ package indexer
 
 import (
 	"encoding/json"
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/Neurostep/go-nate/internal/links"
 	"github.com/Neurostep/go-nate/internal/logger"
+	"github.com/Neurostep/go-nate/internal/progress"
+	"github.com/Neurostep/go-nate/internal/urlnorm"
 	"github.com/blevesearch/bleve/v2"
 	"github.com/dgraph-io/badger/v3"
 	"time"
@@ -13,6 +17,9 @@ type (
 		i  bleve.Index
 		db *badger.DB
 		l  *logger.Logger
+		pr progress.Sink
+		// g is the link graph, it's built once and then reused by the bookmarks indexed one by one
+		g *links.Graph
 	}
 )
 
@@ -20,16 +27,43 @@ const (
 	batchSize = 100
 )
 
-func New(i bleve.Index, db *badger.DB, l *logger.Logger) *Indexer {
+// New returns the indexer of the bookmarks of the DB, the progress receives its events, nil discards them.
+func New(i bleve.Index, db *badger.DB, l *logger.Logger, pr progress.Sink) *Indexer {
+	if pr == nil {
+		pr = progress.Discard
+	}
+
 	return &Indexer{
 		i:  i,
 		db: db,
 		l:  l,
+		pr: pr,
 	}
 }
 
-func (idx *Indexer) IndexBookmark(href string) error {
-	err := idx.db.View(func(txn *badger.Txn) error {
+// IndexBookmark indexes the bookmark. Its rank and links are computed over the whole link graph, which is built
+// the first time it's needed and kept for the lifetime of the indexer, so the bookmarks indexed one by one don't
+// rebuild it. The bookmarks it links to or is linked from are not re-indexed, so the ranks are only consistent
+// after IndexBookmarks.
+func (idx *Indexer) IndexBookmark(href string) (err error) {
+	href = urlnorm.Key(href)
+
+	idx.emit(progress.Event{Kind: progress.Started, Total: 1})
+	defer func() {
+		finished := progress.Event{Kind: progress.Finished, Total: 1}
+		if err != nil {
+			idx.emit(progress.Event{Kind: progress.Failed, URL: href, Reason: err.Error()})
+			finished.Failed = 1
+		}
+		idx.emit(finished)
+	}()
+
+	g, err := idx.graph()
+	if err != nil {
+		return err
+	}
+
+	err = idx.db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(href))
 		if err != nil {
 			return err
@@ -45,7 +79,7 @@ func (idx *Indexer) IndexBookmark(href string) error {
 			return err
 		}
 
-		var jsonDoc map[string]string
+		var jsonDoc map[string]interface{}
 		err = json.Unmarshal(valCopy, &jsonDoc)
 		if err != nil {
 			return err
@@ -54,11 +88,19 @@ func (idx *Indexer) IndexBookmark(href string) error {
 		for k, _ := range SupportedLanguages {
 			delete(jsonDoc, k+"_html")
 		}
+		addLinks(jsonDoc, g.Node(href))
 
+		for _, id := range staleIDs(href, jsonDoc) {
+			err = idx.i.Delete(id)
+			if err != nil {
+				return err
+			}
+		}
 		err = idx.i.Index(href, jsonDoc)
 		if err != nil {
 			return err
 		}
+		idx.emit(progress.Event{Kind: progress.Indexed, URL: href})
 
 		return nil
 	})
@@ -66,13 +108,48 @@ func (idx *Indexer) IndexBookmark(href string) error {
 	return err
 }
 
-func (idx *Indexer) IndexBookmarks() error {
+// IndexBookmarks indexes all the bookmarks. The link graph is built anew, so the ranks of all the bookmarks
+// are consistent, and it's kept for the bookmarks indexed later.
+func (idx *Indexer) IndexBookmarks() (err error) {
 	count := 0
 	startTime := time.Now()
 	batch := idx.i.NewBatch()
 	batchCount := 0
+	// pending are the bookmarks of the batch which is not indexed yet
+	var pending []string
+
+	g, err := links.New(idx.db).Graph()
+	if err != nil {
+		return err
+	}
+	idx.g = g
+
+	idx.emit(progress.Event{Kind: progress.Started, Total: g.Len()})
+	defer func() {
+		finished := progress.Event{Kind: progress.Finished, Total: count}
+		if err != nil {
+			idx.emit(progress.Event{Kind: progress.Failed, Reason: err.Error()})
+			finished.Failed = len(pending)
+		}
+		idx.emit(finished)
+	}()
+
+	flush := func() error {
+		err := idx.i.Batch(batch)
+		if err != nil {
+			return err
+		}
+		for _, href := range pending {
+			idx.emit(progress.Event{Kind: progress.Indexed, URL: href})
+		}
+		batch = idx.i.NewBatch()
+		batchCount = 0
+		pending = pending[:0]
 
-	err := idx.db.View(func(txn *badger.Txn) error {
+		return nil
+	}
+
+	err = idx.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchSize = batchSize
 
@@ -81,8 +158,11 @@ func (idx *Indexer) IndexBookmarks() error {
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
 			k := item.Key()
+			if !keys.IsBookmark(k) {
+				continue
+			}
 			err := item.Value(func(v []byte) error {
-				var jsonDoc map[string]string
+				var jsonDoc map[string]interface{}
 				err := json.Unmarshal(v, &jsonDoc)
 				if err != nil {
 					return err
@@ -92,21 +172,24 @@ func (idx *Indexer) IndexBookmarks() error {
 				for k, _ := range SupportedLanguages {
 					delete(jsonDoc, k+"_html")
 				}
+				addLinks(jsonDoc, g.Node(string(k)))
 
+				for _, id := range staleIDs(string(k), jsonDoc) {
+					batch.Delete(id)
+				}
 				err = batch.Index(string(k), jsonDoc)
 				if err != nil {
 					return err
 				}
 
 				batchCount++
+				pending = append(pending, string(k))
 
 				if batchCount >= batchSize {
-					err = idx.i.Batch(batch)
+					err = flush()
 					if err != nil {
 						return err
 					}
-					batch = idx.i.NewBatch()
-					batchCount = 0
 				}
 				count++
 				if count%1000 == 0 {
@@ -128,7 +211,7 @@ func (idx *Indexer) IndexBookmarks() error {
 	}
 
 	if batchCount > 0 {
-		err = idx.i.Batch(batch)
+		err = flush()
 		if err != nil {
 			return err
 		}
@@ -141,3 +224,55 @@ func (idx *Indexer) IndexBookmarks() error {
 
 	return nil
 }
+
+// graph returns the link graph, building it the first time.
+func (idx *Indexer) graph() (*links.Graph, error) {
+	if idx.g != nil {
+		return idx.g, nil
+	}
+
+	g, err := links.New(idx.db).Graph()
+	if err != nil {
+		return nil, err
+	}
+	idx.g = g
+
+	return g, nil
+}
+
+func (idx *Indexer) emit(e progress.Event) {
+	e.Stage = progress.StageIndex
+	e.Time = time.Now().UTC()
+	idx.pr.Emit(e)
+}
+
+// addLinks adds the place of the bookmark in the link graph to the document.
+func addLinks(doc map[string]interface{}, n *links.Node) {
+	if n == nil {
+		return
+	}
+
+	doc["rank"] = n.Rank
+	doc["links_to"] = n.LinksTo
+	doc["linked_from"] = n.LinkedFrom
+}
+
+// staleIDs returns the IDs the bookmark could be indexed under before its URL was normalized, they are its raw URL
+// and its aliases.
+func staleIDs(key string, doc map[string]interface{}) []string {
+	var ids []string
+	add := func(v interface{}) {
+		if id, ok := v.(string); ok && id != "" && id != key {
+			ids = append(ids, id)
+		}
+	}
+
+	add(doc["url"])
+	if aliases, ok := doc["aliases"].([]interface{}); ok {
+		for _, a := range aliases {
+			add(a)
+		}
+	}
+
+	return ids
+}
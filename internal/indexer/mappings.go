@@ -53,6 +53,8 @@ func BuildIndexMapping() (mapping.IndexMapping, error) {
 	keywordFieldMapping := bleve.NewTextFieldMapping()
 	keywordFieldMapping.Analyzer = keyword.Name
 
+	dateFieldMapping := bleve.NewDateTimeFieldMapping()
+
 	bookmarkMapping := bleve.NewDocumentMapping()
 
 	for k, _ := range SupportedLanguages {
@@ -69,10 +71,65 @@ func BuildIndexMapping() (mapping.IndexMapping, error) {
 
 	bookmarkMapping.AddFieldMappingsAt("author", keywordFieldMapping)
 	bookmarkMapping.AddFieldMappingsAt("lang", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("lang_script", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("lang_override", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("title_lang", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("title_lang_override", keywordFieldMapping)
 	bookmarkMapping.AddFieldMappingsAt("siteName", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("status", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("etag", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("last_modified", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("content_hash", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("charset", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("warc_file", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("health", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("http_status", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("content_type", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("favicon_id", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("image_id", keywordFieldMapping)
+
+	// metadata the page declares
+	bookmarkMapping.AddFieldMappingsAt("description", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("og_title", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("og_description", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("twitter_title", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("twitter_description", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("og_url", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("og_image", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("twitter_image", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("image_url", rootTextFieldMapping)
+	for _, f := range []string{
+		"keywords", "tags", "section", "schema_type", "schema_authors",
+		"og_type", "og_site_name", "og_locale", "twitter_card", "twitter_site", "twitter_creator",
+	} {
+		bookmarkMapping.AddFieldMappingsAt(f, keywordFieldMapping)
+	}
+
+	bookmarkMapping.AddFieldMappingsAt("final_url", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("checked_url", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("canonical_url", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("redirects", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("duplicates", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("aliases", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("attempts", rootTextFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("duplicate", bleve.NewBooleanFieldMapping())
+	bookmarkMapping.AddFieldMappingsAt("offline", bleve.NewBooleanFieldMapping())
+	bookmarkMapping.AddFieldMappingsAt("content_length", bleve.NewNumericFieldMapping())
+	bookmarkMapping.AddFieldMappingsAt("rank", bleve.NewNumericFieldMapping())
+	bookmarkMapping.AddFieldMappingsAt("lang_confidence", bleve.NewNumericFieldMapping())
+	bookmarkMapping.AddFieldMappingsAt("title_lang_confidence", bleve.NewNumericFieldMapping())
+	bookmarkMapping.AddFieldMappingsAt("links_to", keywordFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("linked_from", keywordFieldMapping)
+
+	bookmarkMapping.AddFieldMappingsAt("fetched_at", dateFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("checked_at", dateFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("published_at", dateFieldMapping)
+	bookmarkMapping.AddFieldMappingsAt("modified_at", dateFieldMapping)
 
 	indexMapping := bleve.NewIndexMapping()
 	indexMapping.AddDocumentMapping("bookmark", bookmarkMapping)
+	// the records have no type, so they'd get the dynamic mapping otherwise
+	indexMapping.DefaultMapping = bookmarkMapping
 
 	indexMapping.TypeField = "type"
 	indexMapping.DefaultAnalyzer = "en"
@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"os"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MappingVersion is the version of the mapping BuildIndexMapping builds, it has to be bumped whenever
+	// the mapping changes, as the index keeps the mapping it's been created with
+	MappingVersion = "2"
+
+	_mappingVersionKey = "mapping_version"
+)
+
+var (
+	ErrStaleMapping = errors.New("index is created with the outdated mapping, run 'go-nate index' to rebuild it")
+)
+
+// CheckMapping returns ErrStaleMapping if the index is created with the other mapping than the current one,
+// the indexes created before the mapping got its version included.
+func CheckMapping(i bleve.Index) error {
+	v, err := i.GetInternal([]byte(_mappingVersionKey))
+	if err != nil {
+		return err
+	}
+	if string(v) != MappingVersion {
+		return ErrStaleMapping
+	}
+
+	return nil
+}
+
+// Create creates the empty index at the path with the current mapping, replacing the one which is there.
+func Create(path string) (bleve.Index, error) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't remove index")
+	}
+
+	m, err := BuildIndexMapping()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't build index mapping")
+	}
+
+	i, err := bleve.New(path, m)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create index")
+	}
+
+	err = i.SetInternal([]byte(_mappingVersionKey), []byte(MappingVersion))
+	if err != nil {
+		i.Close()
+		return nil, err
+	}
+
+	return i, nil
+}
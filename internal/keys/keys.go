@@ -0,0 +1,37 @@
+package keys
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Bookmarks are stored in badger under their URL. Everything else go-nate keeps
+// in the same DB lives under a namespaced key, which starts with the separator,
+// so it could never be mistaken for a bookmark.
+const Separator = "!"
+
+// Key builds a namespaced key out of the parts.
+func Key(ns string, parts ...string) []byte {
+	return []byte(Separator + ns + Separator + strings.Join(parts, Separator))
+}
+
+// Prefix returns the prefix of the keys in the namespace which start with the parts.
+func Prefix(ns string, parts ...string) []byte {
+	k := Key(ns, parts...)
+	if len(parts) > 0 {
+		k = append(k, Separator...)
+	}
+
+	return k
+}
+
+// IsBookmark reports whether the key is a bookmark one.
+func IsBookmark(key []byte) bool {
+	return !bytes.HasPrefix(key, []byte(Separator))
+}
+
+// PastNamespaces returns the key sorted right after all the namespaced ones, so the iterator seeking to it
+// skips them and goes on with the bookmarks.
+func PastNamespaces() []byte {
+	return []byte{Separator[0] + 1}
+}
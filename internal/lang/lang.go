@@ -0,0 +1,140 @@
+package lang
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/abadojack/whatlanggo"
+)
+
+type (
+	Props struct {
+		// Languages are the languages to be detected, by the names they are known by, e.g. the analyzers
+		// the text in the language is indexed with
+		Languages map[string]whatlanggo.Lang
+		// Fallback is the language of the text which isn't detected confidently, it's one of the Languages
+		Fallback string
+		// MinConfidence is the confidence of the detection below which it isn't trusted,
+		// DefaultMinConfidence is used if it's zero
+		MinConfidence float64
+	}
+
+	// Detector detects the languages of the bookmarks, picking from the languages it's been given only.
+	Detector struct {
+		names         map[whatlanggo.Lang]string
+		opts          whatlanggo.Options
+		fallback      string
+		minConfidence float64
+	}
+
+	// Detection is the language of the text along with the confidence of the detector and the script the text
+	// is written in, e.g. "Latin" or "Cyrillic". The confidence is zero if the language isn't detected but assumed.
+	Detection struct {
+		Lang       string  `json:"lang"`
+		Confidence float64 `json:"confidence"`
+		Script     string  `json:"script,omitempty"`
+	}
+)
+
+const (
+	DefaultMinConfidence = whatlanggo.ReliableConfidenceThreshold
+)
+
+func New(props Props) *Detector {
+	d := &Detector{
+		names:         map[whatlanggo.Lang]string{},
+		opts:          whatlanggo.Options{Whitelist: map[whatlanggo.Lang]bool{}},
+		fallback:      props.Fallback,
+		minConfidence: props.MinConfidence,
+	}
+	for name, l := range props.Languages {
+		d.names[l] = name
+		d.opts.Whitelist[l] = true
+	}
+	if d.minConfidence <= 0 {
+		d.minConfidence = DefaultMinConfidence
+	}
+
+	return d
+}
+
+// Detect returns the language of the text, it's only confident if the detected language is one of the languages
+// of the detector and the detection is trusted.
+func (d *Detector) Detect(text string) (Detection, bool) {
+	info := whatlanggo.DetectWithOptions(text, d.opts)
+
+	det := Detection{Script: whatlanggo.Scripts[info.Script]}
+	name, ok := d.names[info.Lang]
+	if !ok || info.Confidence < d.minConfidence {
+		return det, false
+	}
+	det.Lang = name
+	det.Confidence = info.Confidence
+
+	return det, true
+}
+
+// Document returns the language of the document content and the one of its title. The content language is
+// the one of the first text detected confidently, the texts are expected to go from the main one, which is
+// the longest, to the shortest. If none of them is, the title is tried, and then the fallback is assumed.
+// The title is short, so its language is assumed to be the content one unless it's detected confidently.
+func (d *Detector) Document(title string, texts ...string) (content Detection, titleDet Detection) {
+	titleDet, titleOk := d.Detect(title)
+
+	found := false
+	for _, t := range texts {
+		if strings.TrimSpace(t) == "" {
+			continue
+		}
+
+		det, ok := d.Detect(t)
+		if content.Script == "" {
+			// the script of the main text, even if its language is unknown
+			content.Script = det.Script
+		}
+		if ok {
+			content.Lang, content.Confidence = det.Lang, det.Confidence
+			found = true
+			break
+		}
+	}
+
+	switch {
+	case found:
+	case titleOk:
+		content.Lang = titleDet.Lang
+		if content.Script == "" {
+			content.Script = titleDet.Script
+		}
+	default:
+		content.Lang = d.fallback
+	}
+
+	if !titleOk {
+		titleDet = Detection{Lang: content.Lang, Script: titleDet.Script}
+	}
+
+	return content, titleDet
+}
+
+// Supported tells if the detector knows the language by the name.
+func (d *Detector) Supported(name string) bool {
+	for _, n := range d.names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Languages returns the names of the languages of the detector, sorted.
+func (d *Detector) Languages() []string {
+	res := make([]string, 0, len(d.names))
+	for _, n := range d.names {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+
+	return res
+}
@@ -0,0 +1,230 @@
+package links
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/Neurostep/go-nate/internal/urlnorm"
+	"github.com/dgraph-io/badger/v3"
+)
+
+type (
+	// Store keeps the outbound links of the bookmarks, as they were on the page the latest time it was dumped.
+	Store struct {
+		db *badger.DB
+	}
+
+	// Graph is the graph of the bookmarks linking to each other, the links to the pages which are not bookmarked
+	// are not there. The bookmarks are identified by their normalized URLs.
+	Graph struct {
+		urls map[string]string
+		out  map[string][]string
+		in   map[string][]string
+		rank map[string]float64
+	}
+
+	// Node is the bookmark along with the bookmarks it links to and the ones linking to it.
+	Node struct {
+		URL        string   `json:"url"`
+		Rank       float64  `json:"rank"`
+		LinksTo    []string `json:"links_to"`
+		LinkedFrom []string `json:"linked_from"`
+	}
+
+	// bookmark is the part of the dumped bookmark telling the URLs it's known by
+	bookmark struct {
+		URL          string   `json:"url"`
+		FinalURL     string   `json:"final_url"`
+		CanonicalURL string   `json:"canonical_url"`
+		Aliases      []string `json:"aliases"`
+	}
+)
+
+const (
+	_namespace = "links"
+)
+
+func New(db *badger.DB) *Store {
+	return &Store{db: db}
+}
+
+// Put replaces the outbound links of the bookmark.
+func (s *Store) Put(href string, links []string) error {
+	key := keys.Key(_namespace, urlnorm.Key(href))
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		if len(links) == 0 {
+			return txn.Delete(key)
+		}
+
+		val, err := json.Marshal(links)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(key, val)
+	})
+}
+
+// Get returns the outbound links of the bookmark.
+func (s *Store) Get(href string) ([]string, error) {
+	var links []string
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keys.Key(_namespace, urlnorm.Key(href)))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &links)
+		})
+	})
+
+	return links, err
+}
+
+// Graph resolves the outbound links of all the bookmarks into the graph. The link leads to the bookmark
+// if it's the URL of the bookmark, one of its aliases, the URL it redirects to or its canonical one.
+func (s *Store) Graph() (*Graph, error) {
+	g := &Graph{
+		urls: map[string]string{},
+		out:  map[string][]string{},
+		in:   map[string][]string{},
+	}
+	// resolved is the bookmark by every URL it's known by
+	resolved := map[string]string{}
+	outbound := map[string][]string{}
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		// the namespaced keys are sorted together, so the bookmarks are iterated skipping them at once
+		for it.Rewind(); it.Valid(); {
+			item := it.Item()
+			if !keys.IsBookmark(item.Key()) {
+				it.Seek(keys.PastNamespaces())
+				continue
+			}
+
+			var b bookmark
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &b)
+			})
+			if err != nil {
+				return err
+			}
+
+			k := string(item.Key())
+			g.urls[k] = b.URL
+			for _, u := range append([]string{b.URL, b.FinalURL, b.CanonicalURL}, b.Aliases...) {
+				if u == "" {
+					continue
+				}
+				// the bookmark itself wins over the one redirecting to it
+				if _, ok := resolved[urlnorm.Key(u)]; !ok || urlnorm.Key(u) == k {
+					resolved[urlnorm.Key(u)] = k
+				}
+			}
+
+			it.Next()
+		}
+
+		linksPrefix := keys.Prefix(_namespace)
+		for it.Seek(linksPrefix); it.ValidForPrefix(linksPrefix); it.Next() {
+			item := it.Item()
+
+			var ls []string
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &ls)
+			})
+			if err != nil {
+				return err
+			}
+			outbound[strings.TrimPrefix(string(item.Key()), string(linksPrefix))] = ls
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for from, ls := range outbound {
+		if _, ok := g.urls[from]; !ok {
+			// the links of the bookmark which is gone
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, l := range ls {
+			to, ok := resolved[urlnorm.Key(l)]
+			if !ok || to == from || seen[to] {
+				continue
+			}
+			seen[to] = true
+
+			g.out[from] = append(g.out[from], to)
+			g.in[to] = append(g.in[to], from)
+		}
+	}
+	g.rank = pageRank(g)
+
+	return g, nil
+}
+
+// Node returns the bookmark along with its links, nil if there is no such bookmark.
+func (g *Graph) Node(href string) *Node {
+	return g.node(urlnorm.Key(href))
+}
+
+// Len returns the number of the bookmarks.
+func (g *Graph) Len() int {
+	return len(g.urls)
+}
+
+// Nodes returns every bookmark of the graph, by the URL.
+func (g *Graph) Nodes() []*Node {
+	res := make([]*Node, 0, len(g.urls))
+	for k := range g.urls {
+		res = append(res, g.node(k))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].URL < res[j].URL
+	})
+
+	return res
+}
+
+func (g *Graph) node(k string) *Node {
+	u, ok := g.urls[k]
+	if !ok {
+		return nil
+	}
+
+	return &Node{
+		URL:        u,
+		Rank:       g.rank[k],
+		LinksTo:    g.urlsOf(g.out[k]),
+		LinkedFrom: g.urlsOf(g.in[k]),
+	}
+}
+
+func (g *Graph) urlsOf(ks []string) []string {
+	res := make([]string, 0, len(ks))
+	for _, k := range ks {
+		res = append(res, g.urls[k])
+	}
+	sort.Strings(res)
+
+	return res
+}
@@ -0,0 +1,58 @@
+package links
+
+import (
+	"math"
+)
+
+const (
+	// _damping is the probability the surfer follows a link rather than jumps to a random bookmark
+	_damping       = 0.85
+	_maxIterations = 100
+	_tolerance     = 1e-9
+)
+
+// pageRank computes the PageRank of the bookmarks. The ranks are scaled by the number of the bookmarks,
+// so the average rank is 1, and the bookmark ranked above 1 is linked to more than the average one.
+func pageRank(g *Graph) map[string]float64 {
+	n := float64(len(g.urls))
+	rank := make(map[string]float64, len(g.urls))
+	if n == 0 {
+		return rank
+	}
+
+	for k := range g.urls {
+		rank[k] = 1 / n
+	}
+
+	for i := 0; i < _maxIterations; i++ {
+		// the rank of the bookmarks linking nowhere is spread over all of them
+		var dangling float64
+		for k, r := range rank {
+			if len(g.out[k]) == 0 {
+				dangling += r
+			}
+		}
+
+		next := make(map[string]float64, len(rank))
+		var diff float64
+		for k := range g.urls {
+			r := (1-_damping)/n + _damping*dangling/n
+			for _, from := range g.in[k] {
+				r += _damping * rank[from] / float64(len(g.out[from]))
+			}
+			next[k] = r
+			diff += math.Abs(r - rank[k])
+		}
+		rank = next
+
+		if diff < _tolerance {
+			break
+		}
+	}
+
+	for k, r := range rank {
+		rank[k] = r * n
+	}
+
+	return rank
+}
@@ -0,0 +1,466 @@
+package offline
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type (
+	Props struct {
+		// Fetcher retrieves the assets, the HTTP loader is enough as they are static
+		Fetcher dl.Fetcher
+		// Budget bounds the total size of the assets inlined into a single snapshot, DefaultBudget is used if it's zero
+		Budget int64
+		// MaxAssetSize bounds the size of a single asset, DefaultMaxAssetSize is used if it's zero
+		MaxAssetSize int64
+	}
+
+	// Inliner turns the extracted article into the self-contained page, with the images and stylesheets
+	// it references inlined as data URIs.
+	Inliner struct {
+		f        dl.Fetcher
+		budget   int64
+		maxAsset int64
+	}
+
+	// Page is the article to be made into the snapshot.
+	Page struct {
+		URL, Title string
+		// HTML is the extracted content of the article
+		HTML      string
+		FetchedAt string
+	}
+
+	// Stats tells how the assets of the page were handled.
+	Stats struct {
+		// Inlined is the number of the inlined assets, Skipped is the number of the ones left as links,
+		// either because they couldn't be fetched or they didn't fit into the budget
+		Inlined, Skipped int
+		// Size is the total size of the inlined data URIs
+		Size int64
+	}
+
+	// inlining is the state of a single Inline call.
+	inlining struct {
+		*Inliner
+		ctx   context.Context
+		left  int64
+		cache map[string]string
+		stats Stats
+	}
+)
+
+const (
+	DefaultBudget       = 5 << 20
+	DefaultMaxAssetSize = 1 << 20
+)
+
+var (
+	_cssURL    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+	_cssImport = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+	// _cssAssetTypes are the types of the assets CSS refers to, fonts are served with whatever type
+	_cssAssetTypes = []string{"image/", "font/", "application/font", "application/x-font", "application/vnd.ms-fontobject", "application/octet-stream"}
+
+	_pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 44em; margin: 2em auto; padding: 0 1em; font-family: Georgia, serif; line-height: 1.6; color: #222; }
+img, video, svg { max-width: 100%; height: auto; }
+pre { overflow-x: auto; }
+.gonate-origin { font-family: sans-serif; font-size: .85em; color: #666; border-bottom: 1px solid #ddd; padding-bottom: .5em; }
+</style>
+</head>
+<body>
+<p class="gonate-origin">Offline copy of <a href="{{.URL}}">{{.URL}}</a>{{if .FetchedAt}}, saved at {{.FetchedAt}}{{end}}</p>
+<h1>{{.Title}}</h1>
+<article>
+{{.Content}}
+</article>
+</body>
+</html>
+`))
+)
+
+func New(props Props) *Inliner {
+	in := &Inliner{f: props.Fetcher, budget: props.Budget, maxAsset: props.MaxAssetSize}
+	if in.budget <= 0 {
+		in.budget = DefaultBudget
+	}
+	if in.maxAsset <= 0 {
+		in.maxAsset = DefaultMaxAssetSize
+	}
+
+	return in
+}
+
+// Inline builds the snapshot of the page. The relative links are resolved against the URL of the page,
+// the assets which aren't inlined are kept as absolute links, so they still work while the origin is up.
+func (in *Inliner) Inline(ctx context.Context, p Page) ([]byte, Stats, error) {
+	base, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, Stats{}, errors.Wrapf(err, "couldn't parse URL %s", p.URL)
+	}
+
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(p.HTML), body)
+	if err != nil {
+		return nil, Stats{}, errors.Wrap(err, "couldn't parse content")
+	}
+
+	s := &inlining{Inliner: in, ctx: ctx, left: in.budget, cache: map[string]string{}}
+
+	var content bytes.Buffer
+	for _, n := range nodes {
+		// the top level nodes have no parent to be removed from, so they are skipped
+		if dropped(n) {
+			continue
+		}
+		s.walk(n, base)
+
+		err = html.Render(&content, n)
+		if err != nil {
+			return nil, Stats{}, errors.Wrap(err, "couldn't render content")
+		}
+	}
+
+	var page bytes.Buffer
+	err = _pageTemplate.Execute(&page, struct {
+		URL, Title, FetchedAt string
+		Content               template.HTML
+	}{p.URL, p.Title, p.FetchedAt, template.HTML(content.String())})
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	return page.Bytes(), s.stats, ctx.Err()
+}
+
+// walk rewrites the references of the node and its descendants. Scripts, frames and the like are dropped,
+// as the snapshot is supposed to be static.
+func (s *inlining) walk(n *html.Node, base *url.URL) {
+	if dropped(n) {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+		return
+	}
+
+	if n.Type == html.ElementNode {
+		switch n.DataAtom {
+		case atom.Img:
+			s.image(n, base)
+		case atom.Source:
+			if n.Parent != nil && n.Parent.DataAtom == atom.Picture {
+				// the <img> of the picture is the one kept
+				n.Parent.RemoveChild(n)
+				return
+			}
+			s.absolute(n, base, "src")
+			dropAttr(n, "srcset")
+		case atom.Link:
+			if hasToken(attr(n, "rel"), "stylesheet") {
+				s.stylesheet(n, base)
+			} else {
+				s.absolute(n, base, "href")
+			}
+		case atom.Style:
+			if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+				n.FirstChild.Data = s.css(n.FirstChild.Data, base)
+			}
+		case atom.A, atom.Area:
+			s.absolute(n, base, "href")
+		case atom.Video, atom.Audio, atom.Track:
+			s.absolute(n, base, "src")
+			s.absolute(n, base, "poster")
+		}
+
+		for i := 0; i < len(n.Attr); i++ {
+			a := &n.Attr[i]
+			switch {
+			case strings.HasPrefix(strings.ToLower(a.Key), "on"):
+				// event handlers are scripts as well
+				n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+				i--
+			case a.Key == "style":
+				a.Val = s.css(a.Val, base)
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		s.walk(c, base)
+		c = next
+	}
+}
+
+// dropped tells if the node is the one dropped from the snapshot, see walk.
+func dropped(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Noscript, atom.Iframe, atom.Frame, atom.Object, atom.Embed, atom.Base:
+		return true
+	}
+
+	return false
+}
+
+// image inlines the source of the image. The largest candidate of srcset is picked if there is no src.
+func (s *inlining) image(n *html.Node, base *url.URL) {
+	src := attr(n, "src")
+	// lazy loaded images have a placeholder in src
+	if src == "" || (strings.HasPrefix(src, "data:") && attr(n, "srcset") != "") {
+		src = largestCandidate(attr(n, "srcset"))
+	}
+	dropAttr(n, "srcset")
+	dropAttr(n, "sizes")
+	if src == "" {
+		return
+	}
+
+	setAttr(n, "src", s.asset(base, src, "image/"))
+}
+
+// stylesheet replaces the link to the stylesheet with the inlined one.
+func (s *inlining) stylesheet(n *html.Node, base *url.URL) {
+	href := attr(n, "href")
+	u, err := base.Parse(href)
+	if href == "" || err != nil {
+		return
+	}
+
+	setAttr(n, "href", s.sheet(u))
+}
+
+// sheet returns the data URI of the stylesheet with its own references inlined, or its URL if it's not inlined.
+func (s *inlining) sheet(u *url.URL) string {
+	href := u.String()
+	if uri, ok := s.cache[href]; ok {
+		return uri
+	}
+	// the stylesheet importing itself ends up as a link
+	s.cache[href] = href
+
+	res, err := s.fetch(href, "text/css")
+	if err != nil {
+		s.stats.Skipped++
+		return href
+	}
+
+	// references of the stylesheet are relative to the stylesheet itself
+	css := s.css(string(res.Body), u)
+	s.cache[href] = s.dataURI(href, "text/css;charset=utf-8", []byte(css))
+
+	return s.cache[href]
+}
+
+// css inlines the images and fonts the CSS refers to with url(), the imported stylesheets are inlined as well.
+func (s *inlining) css(css string, base *url.URL) string {
+	css = _cssImport.ReplaceAllStringFunc(css, func(m string) string {
+		sub := _cssImport.FindStringSubmatch(m)
+		return `@import url("` + sub[1] + sub[2] + `")`
+	})
+
+	return _cssURL.ReplaceAllStringFunc(css, func(m string) string {
+		sub := _cssURL.FindStringSubmatch(m)
+		ref := sub[1] + sub[2] + sub[3]
+		if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+			return m
+		}
+
+		u, err := base.Parse(ref)
+		if err != nil {
+			return m
+		}
+		var uri string
+		if strings.HasSuffix(strings.ToLower(u.Path), ".css") {
+			uri = s.sheet(u)
+		} else {
+			uri = s.asset(u, "", _cssAssetTypes...)
+		}
+
+		return `url("` + uri + `")`
+	})
+}
+
+// asset returns the data URI of the asset if it's one of the expected types and it fits into the budget,
+// otherwise the absolute URL of it.
+func (s *inlining) asset(base *url.URL, ref string, types ...string) string {
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return u.String()
+	}
+
+	href := u.String()
+	if uri, ok := s.cache[href]; ok {
+		return uri
+	}
+
+	res, err := s.fetch(href, types...)
+	if err != nil {
+		s.stats.Skipped++
+		s.cache[href] = href
+		return href
+	}
+
+	s.cache[href] = s.dataURI(href, res.ContentType(), res.Body)
+
+	return s.cache[href]
+}
+
+// dataURI encodes the asset if it fits into what's left of the budget, otherwise the URL of it is returned.
+func (s *inlining) dataURI(href, mediaType string, body []byte) string {
+	uri := "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(body)
+	if int64(len(uri)) > s.left {
+		s.stats.Skipped++
+		return href
+	}
+
+	s.left -= int64(len(uri))
+	s.stats.Inlined++
+	s.stats.Size += int64(len(uri))
+
+	return uri
+}
+
+// fetch retrieves the asset, making sure it's of the expected type and not too large.
+func (s *inlining) fetch(href string, types ...string) (*dl.Result, error) {
+	if s.ctx.Err() != nil {
+		return nil, s.ctx.Err()
+	}
+
+	res, err := s.f.Fetch(s.ctx, href)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("asset %s responded with %d", href, res.StatusCode)
+	}
+	if res.TooLarge || int64(len(res.Body)) > s.maxAsset {
+		return nil, errors.Errorf("asset %s is larger than %d bytes", href, s.maxAsset)
+	}
+
+	ct := res.ContentType()
+	for _, t := range types {
+		if strings.HasPrefix(ct, t) {
+			return res, nil
+		}
+	}
+	return nil, errors.Errorf("asset %s is %s", href, ct)
+}
+
+// largestCandidate picks the candidate of srcset with the largest width or density descriptor.
+func largestCandidate(srcset string) string {
+	var (
+		best  string
+		bestW float64
+	)
+	for _, c := range strings.Split(srcset, ",") {
+		fs := strings.Fields(c)
+		if len(fs) == 0 {
+			continue
+		}
+
+		// the descriptor is either the width, e.g. "640w", or the density, e.g. "2x"
+		w := 1.0
+		if len(fs) > 1 && len(fs[1]) > 1 {
+			if n, err := strconv.ParseFloat(fs[1][:len(fs[1])-1], 64); err == nil {
+				w = n
+			}
+		}
+		if best == "" || w > bestW {
+			best, bestW = fs[0], w
+		}
+	}
+
+	return best
+}
+
+// absolute resolves the URL in the attribute against the base, the scripts are replaced with the empty fragment.
+func (s *inlining) absolute(n *html.Node, base *url.URL, key string) {
+	v := attr(n, key)
+	if isScript(v) {
+		// the snapshot is static, the link doing nothing is left instead
+		setAttr(n, key, "#")
+		return
+	}
+	if v == "" || strings.HasPrefix(v, "#") || strings.HasPrefix(v, "data:") {
+		return
+	}
+	if u, err := base.Parse(v); err == nil {
+		setAttr(n, key, u.String())
+	}
+}
+
+// isScript tells if the URL runs a script, the browsers ignore the tabs and the line breaks in it.
+func isScript(v string) bool {
+	v = strings.ToLower(strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, v))
+
+	return strings.HasPrefix(v, "javascript:") || strings.HasPrefix(v, "vbscript:")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func dropAttr(n *html.Node, key string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
+
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+
+	return false
+}
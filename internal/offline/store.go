@@ -0,0 +1,73 @@
+package offline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Neurostep/go-nate/internal/urlnorm"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Store keeps the snapshots as files in the directory, named after the normalized URL of the bookmark,
+	// so they could be read while the DB is held by the running dump.
+	Store struct {
+		dir string
+	}
+)
+
+const (
+	_fileExt = ".html"
+)
+
+var (
+	ErrNoSnapshot = errors.New("bookmark has no offline snapshot")
+)
+
+func NewStore(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create offline snapshots directory")
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// Put replaces the snapshot of the bookmark. The file is written aside and renamed,
+// so the snapshot being served is never a partial one.
+func (s *Store) Put(href string, page []byte) error {
+	f, err := ioutil.TempFile(s.dir, ".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "couldn't create offline snapshot")
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(page)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "couldn't write offline snapshot")
+	}
+
+	return os.Rename(f.Name(), s.path(href))
+}
+
+// Get returns the snapshot of the bookmark, ErrNoSnapshot if there is none.
+func (s *Store) Get(href string) ([]byte, error) {
+	page, err := ioutil.ReadFile(s.path(href))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSnapshot
+	}
+
+	return page, err
+}
+
+func (s *Store) path(href string) string {
+	sum := sha256.Sum256([]byte(urlnorm.Key(href)))
+
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+_fileExt)
+}
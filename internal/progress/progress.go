@@ -0,0 +1,152 @@
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+type (
+	Kind string
+
+	// Event is what happens to the bookmarks while they are being dumped or indexed.
+	Event struct {
+		Kind Kind `json:"event"`
+		// Stage is either StageDump or StageIndex
+		Stage string    `json:"stage"`
+		Time  time.Time `json:"time"`
+		URL   string    `json:"url,omitempty"`
+		// Loader is the fetcher which has fetched the bookmark or which it has fallen back to
+		Loader     string `json:"loader,omitempty"`
+		StatusCode int    `json:"status_code,omitempty"`
+		// Status is the status the bookmark is saved with, e.g. "ok" or "too large", or "not modified" for the refreshed
+		// bookmark which has not changed
+		Status string `json:"status,omitempty"`
+		// Reason is why the bookmark has failed or why the previous fetcher has been fallen back from
+		Reason string `json:"reason,omitempty"`
+		// Total is the number of the bookmarks the stage has started with or has finished
+		Total int `json:"total,omitempty"`
+		// Failed is the number of the bookmarks which have failed, once the stage is finished
+		Failed int `json:"failed,omitempty"`
+	}
+
+	// Sink receives the events. It's called concurrently, by every worker of the dump.
+	Sink interface {
+		Emit(e Event)
+	}
+
+	// SinkFunc adapts an ordinary function to the Sink interface.
+	SinkFunc func(e Event)
+
+	multi []Sink
+
+	// Bar draws the progress bar of the stage in the terminal.
+	Bar struct {
+		mux sync.Mutex
+		bar *pb.ProgressBar
+	}
+
+	// JSONLines writes every event as a line of JSON.
+	JSONLines struct {
+		mux sync.Mutex
+		enc *json.Encoder
+	}
+
+	// Channel sends the events to the channel, for the consumer in the same process. The consumer is expected
+	// to keep reading them, as the dump waits for the event to be received.
+	Channel struct {
+		C chan Event
+	}
+)
+
+const (
+	Started  Kind = "started"
+	Fetched  Kind = "fetched"
+	FellBack Kind = "fell_back"
+	Saved    Kind = "saved"
+	Failed   Kind = "failed"
+	Indexed  Kind = "indexed"
+	Finished Kind = "finished"
+
+	StageDump  = "dump"
+	StageIndex = "index"
+)
+
+var (
+	// Discard drops the events.
+	Discard Sink = SinkFunc(func(Event) {})
+)
+
+func (f SinkFunc) Emit(e Event) {
+	f(e)
+}
+
+// Multi returns the sink passing the events to every one of the sinks, the nil ones are skipped.
+func Multi(sinks ...Sink) Sink {
+	var m multi
+	for _, s := range sinks {
+		if s != nil {
+			m = append(m, s)
+		}
+	}
+
+	return m
+}
+
+func (m multi) Emit(e Event) {
+	for _, s := range m {
+		s.Emit(e)
+	}
+}
+
+func NewBar() *Bar {
+	return &Bar{}
+}
+
+// Emit starts the bar once the stage is started and moves it for every bookmark which is done with.
+func (b *Bar) Emit(e Event) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	switch e.Kind {
+	case Started:
+		b.bar = pb.StartNew(e.Total)
+	case Saved, Failed, Indexed:
+		if b.bar != nil {
+			b.bar.Increment()
+		}
+	case Finished:
+		if b.bar != nil {
+			b.bar.Finish()
+			b.bar = nil
+		}
+	}
+}
+
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{enc: json.NewEncoder(w)}
+}
+
+func (j *JSONLines) Emit(e Event) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	_ = j.enc.Encode(&e)
+}
+
+// NewChannel returns the sink with the channel buffering up to size events.
+func NewChannel(size int) *Channel {
+	return &Channel{C: make(chan Event, size)}
+}
+
+func (c *Channel) Emit(e Event) {
+	c.C <- e
+}
+
+// Close closes the channel, once no more events are emitted.
+func (c *Channel) Close() {
+	close(c.C)
+}
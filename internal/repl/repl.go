@@ -171,9 +171,14 @@ func (r *Repl) handleSearch(search string) error {
 		if res.Request.Size > 0 {
 			rv = fmt.Sprintf("%d matches, showing %d through %d, took %s\n", res.Total, res.Request.From+1, res.Request.From+len(res.Hits), res.Took)
 			for i, hit := range res.Hits {
-				lang := hit.Fields["lang"]
+				// the title is in its own language, the bookmarks dumped before it had one use the content language
+				lang, ok := hit.Fields["title_lang"]
+				if !ok {
+					lang = hit.Fields["lang"]
+				}
+				title, _ := hit.Fields[fmt.Sprintf("%s_title", lang)].(string)
 				rv += fmt.Sprintf(
-					"%5d. %s - %s (%f)\n", i+res.Request.From+1, hit.Fields[fmt.Sprintf("%s_title", lang)].(string), hit.Fields["url"].(string), hit.Score)
+					"%5d. %s - %s (%f)\n", i+res.Request.From+1, title, hit.Fields["url"].(string), hit.Score)
 			}
 		} else {
 			rv = fmt.Sprintf("%d matches, took %s\n", res.Total, res.Took)
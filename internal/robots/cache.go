@@ -0,0 +1,140 @@
+package robots
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Cache fetches robots.txt once per host and keeps the parsed rules for the agent.
+	Cache struct {
+		mu    sync.Mutex
+		f     dl.Fetcher
+		agent string
+		hosts map[string]*entry
+	}
+
+	entry struct {
+		ready   chan struct{}
+		rules   *Rules
+		err     error
+		expires time.Time
+	}
+)
+
+const (
+	// DefaultAgent is the product token go-nate looks for in robots.txt.
+	DefaultAgent = "go-nate"
+
+	// robots.txt is re-fetched after that long, which matters for the long-running watch
+	_cacheTTL = time.Hour * 24
+)
+
+func NewCache(f dl.Fetcher, agent string) *Cache {
+	if agent == "" {
+		agent = DefaultAgent
+	}
+
+	return &Cache{
+		f:     f,
+		agent: agent,
+		hosts: map[string]*entry{},
+	}
+}
+
+// Allowed reports whether the agent may fetch the URL.
+func (c *Cache) Allowed(ctx context.Context, href string) (bool, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return false, err
+	}
+
+	r, err := c.Rules(ctx, u)
+	if err != nil {
+		return false, err
+	}
+
+	return r.Allowed(u.EscapedPath() + queryOf(u)), nil
+}
+
+// Rules returns the rules of the URL's host, fetching its robots.txt on the first call.
+func (c *Cache) Rules(ctx context.Context, u *url.URL) (*Rules, error) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return AllowAll, nil
+	}
+
+	origin := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	e, ok := c.hosts[origin]
+	if ok && e.expired() {
+		ok = false
+	}
+	if !ok {
+		e = &entry{ready: make(chan struct{})}
+		c.hosts[origin] = e
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		e.rules = c.load(ctx, origin)
+		e.expires = time.Now().Add(_cacheTTL)
+		if err := ctx.Err(); err != nil {
+			// the fetch was interrupted, so its result tells nothing about the host and isn't kept
+			e.rules, e.err = nil, errors.WithStack(err)
+			c.mu.Lock()
+			if c.hosts[origin] == e {
+				delete(c.hosts, origin)
+			}
+			c.mu.Unlock()
+		}
+		close(e.ready)
+	}
+
+	select {
+	case <-e.ready:
+		return e.rules, e.err
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+func (c *Cache) load(ctx context.Context, origin string) *Rules {
+	res, err := c.f.Fetch(ctx, origin+"/robots.txt")
+	if err != nil {
+		// host is unreachable, fetching the page itself will fail anyway
+		return AllowAll
+	}
+
+	switch {
+	case res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusMultipleChoices:
+		return Parse(res.Body, c.agent)
+	case res.StatusCode >= http.StatusInternalServerError:
+		return DisallowAll
+	default:
+		return AllowAll
+	}
+}
+
+func (e *entry) expired() bool {
+	select {
+	case <-e.ready:
+		return time.Now().After(e.expires)
+	default:
+		return false
+	}
+}
+
+func queryOf(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+
+	return "?" + u.RawQuery
+}
@@ -0,0 +1,37 @@
+package robots
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrDisallowed = errors.New("disallowed by robots.txt")
+)
+
+// NewGuard returns the guard of the loaders, which lets the request go once robots.txt of the host allows it and
+// the host's slot comes. The robots cache could be nil if robots.txt is ignored, then only the rate is limited.
+// The same limiters have to guard all the loaders reaching the same hosts.
+func NewGuard(rc *Cache, limiters *HostLimiters) dl.Guard {
+	return func(ctx context.Context, href string) error {
+		u, err := url.Parse(href)
+		if err != nil {
+			return err
+		}
+
+		if rc != nil {
+			allowed, err := rc.Allowed(ctx, href)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				return errors.Wrap(ErrDisallowed, href)
+			}
+		}
+
+		return limiters.Take(ctx, u)
+	}
+}
@@ -0,0 +1,93 @@
+package robots
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/ratelimit"
+)
+
+// HostLimiters keeps a rate limiter per host, slowing it down to the
+// Crawl-delay of the host's robots.txt when it asks for that.
+type HostLimiters struct {
+	mu       sync.Mutex
+	robots   *Cache
+	rate     int
+	limiters map[string]ratelimit.Limiter
+}
+
+// NewHostLimiters returns the limiters letting rate requests per second to every host. The robots cache
+// tells the crawl delays of the hosts, it could be nil if robots.txt is ignored.
+func NewHostLimiters(rc *Cache, rate int) *HostLimiters {
+	return &HostLimiters{
+		robots:   rc,
+		rate:     rate,
+		limiters: map[string]ratelimit.Limiter{},
+	}
+}
+
+// Take waits for the next slot of the URL's host. It gives up once the context is done, returning its error,
+// so the request isn't made.
+func (h *HostLimiters) Take(ctx context.Context, u *url.URL) error {
+	rl := h.get(ctx, u)
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	slot := make(chan struct{})
+	go func() {
+		rl.Take()
+		close(slot)
+	}()
+
+	select {
+	case <-slot:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+func (h *HostLimiters) get(ctx context.Context, u *url.URL) ratelimit.Limiter {
+	h.mu.Lock()
+	rl, ok := h.limiters[u.Host]
+	h.mu.Unlock()
+	if ok {
+		return rl
+	}
+
+	var delay time.Duration
+	if h.robots != nil {
+		r, err := h.robots.Rules(ctx, u)
+		if err == nil {
+			delay = r.CrawlDelay()
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if rl, ok := h.limiters[u.Host]; ok {
+		return rl
+	}
+
+	// no slack, so the host idle for a while doesn't get a burst of requests exceeding its crawl delay
+	rl = ratelimit.New(1, ratelimit.Per(Interval(h.rate, delay)), ratelimit.WithoutSlack)
+	h.limiters[u.Host] = rl
+
+	return rl
+}
+
+// Interval returns how long the requests to the host are apart, given rate requests per second are let to every
+// host, unless its crawl delay is longer.
+func Interval(rate int, crawlDelay time.Duration) time.Duration {
+	interval := time.Second / time.Duration(rate)
+	if crawlDelay > interval {
+		return crawlDelay
+	}
+
+	return interval
+}
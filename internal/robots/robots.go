@@ -0,0 +1,188 @@
+package robots
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Rules are the robots.txt directives applicable to a single user agent.
+	Rules struct {
+		rules      []rule
+		crawlDelay time.Duration
+	}
+
+	rule struct {
+		allow   bool
+		pattern string
+	}
+
+	group struct {
+		agents     []string
+		rules      []rule
+		crawlDelay time.Duration
+	}
+)
+
+const (
+	_wildcardAgent = "*"
+)
+
+var (
+	// AllowAll is used when the host has no robots.txt, or it couldn't be fetched at all.
+	AllowAll = &Rules{}
+	// DisallowAll is used when the host responds to the robots.txt request with a server error.
+	DisallowAll = &Rules{rules: []rule{{allow: false, pattern: "/"}}}
+)
+
+// Parse reads robots.txt content and returns the rules of the group matching
+// the agent. The most specific group wins; the "*" group is used when no other matches.
+func Parse(body []byte, agent string) *Rules {
+	var (
+		groups []*group
+		cur    *group
+		// a group is closed once the first rule after its user-agent lines is met
+		inRules bool
+	)
+
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		line := sc.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:idx]))
+		val := strings.TrimSpace(line[idx+1:])
+
+		switch key {
+		case "user-agent":
+			if cur == nil || inRules {
+				cur = &group{}
+				groups = append(groups, cur)
+				inRules = false
+			}
+			cur.agents = append(cur.agents, strings.ToLower(val))
+		case "allow", "disallow":
+			if cur == nil {
+				continue
+			}
+			inRules = true
+			// empty disallow means allow all, which is the default anyway
+			if val == "" {
+				continue
+			}
+			cur.rules = append(cur.rules, rule{allow: key == "allow", pattern: val})
+		case "crawl-delay":
+			if cur == nil {
+				continue
+			}
+			inRules = true
+			secs, err := strconv.ParseFloat(val, 64)
+			if err != nil || secs < 0 {
+				continue
+			}
+			cur.crawlDelay = time.Duration(secs * float64(time.Second))
+		}
+	}
+
+	agent = strings.ToLower(agent)
+
+	var matched []*group
+	bestLen := -1
+	for _, g := range groups {
+		for _, a := range g.agents {
+			l := -1
+			if a == _wildcardAgent {
+				l = 0
+			} else if a != "" && strings.Contains(agent, a) {
+				l = len(a)
+			}
+
+			if l < 0 || l < bestLen {
+				continue
+			}
+			if l > bestLen {
+				matched = nil
+				bestLen = l
+			}
+			matched = append(matched, g)
+		}
+	}
+
+	r := &Rules{}
+	for _, g := range matched {
+		r.rules = append(r.rules, g.rules...)
+		if g.crawlDelay > r.crawlDelay {
+			r.crawlDelay = g.crawlDelay
+		}
+	}
+
+	return r
+}
+
+// Allowed reports whether the path (with the query, if any) may be fetched.
+// The longest matching rule wins, allow wins on a tie.
+func (r *Rules) Allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allowed := true
+	best := -1
+	for _, rl := range r.rules {
+		if !match(rl.pattern, path) {
+			continue
+		}
+		l := len(rl.pattern)
+		if l > best || (l == best && rl.allow) {
+			best = l
+			allowed = rl.allow
+		}
+	}
+
+	return allowed
+}
+
+// CrawlDelay returns the delay between requests requested by the host, zero if none.
+func (r *Rules) CrawlDelay() time.Duration {
+	return r.crawlDelay
+}
+
+// match checks the path against the pattern supporting "*" wildcard and "$" end anchor.
+func match(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+
+	for i := 1; i < len(parts); i++ {
+		p := parts[i]
+		if i == len(parts)-1 && anchored {
+			return strings.HasSuffix(path[pos:], p)
+		}
+		idx := strings.Index(path[pos:], p)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(p)
+	}
+
+	return !anchored || pos == len(path)
+}
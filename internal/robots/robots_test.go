@@ -0,0 +1,181 @@
+package robots
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+)
+
+const _robotsTxt = `
+# comments and blank lines are skipped
+
+User-agent: *
+Disallow: /private/
+Allow: /private/public
+Crawl-delay: 1
+
+User-agent: Go-Nate/1.0
+User-agent: other-bot
+Disallow: /search
+Disallow: /*.pdf$
+Allow: /search/about   # trailing comment
+Disallow:
+Crawl-delay: 2.5
+
+User-agent: go-nate-images
+Disallow: /
+`
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		agent   string
+		path    string
+		allowed bool
+	}{
+		// the group of the agent wins over "*", even though it doesn't mention the path
+		{agent: "go-nate/1.0", path: "/private/page", allowed: true},
+		{agent: "go-nate/1.0", path: "/search?q=1", allowed: false},
+		// the longest matching rule wins
+		{agent: "go-nate/1.0", path: "/search/about", allowed: true},
+		{agent: "go-nate/1.0", path: "/docs/a.pdf", allowed: false},
+		{agent: "go-nate/1.0", path: "/docs/a.pdf?download=1", allowed: true},
+		// the longer product token is the more specific one
+		{agent: "go-nate-images", path: "/anything", allowed: false},
+		{agent: "unknown", path: "/private/page", allowed: false},
+		{agent: "unknown", path: "/private/public/page", allowed: true},
+		{agent: "unknown", path: "", allowed: true},
+		// robots.txt itself is always allowed
+		{agent: "go-nate-images", path: "/robots.txt", allowed: true},
+	}
+
+	for _, c := range cases {
+		r := Parse([]byte(_robotsTxt), c.agent)
+		if got := r.Allowed(c.path); got != c.allowed {
+			t.Errorf("%s: Allowed(%q) = %t, want %t", c.agent, c.path, got, c.allowed)
+		}
+	}
+}
+
+func TestParseCrawlDelay(t *testing.T) {
+	cases := []struct {
+		body  string
+		agent string
+		want  time.Duration
+	}{
+		{body: _robotsTxt, agent: "go-nate/1.0", want: 2500 * time.Millisecond},
+		{body: _robotsTxt, agent: "unknown", want: time.Second},
+		{body: _robotsTxt, agent: "go-nate-images", want: 0},
+		{body: "User-agent: *\nCrawl-delay: soon\n", agent: "go-nate", want: 0},
+		{body: "User-agent: *\nCrawl-delay: -3\n", agent: "go-nate", want: 0},
+		// the rules before any user-agent line belong to no group
+		{body: "Crawl-delay: 5\nDisallow: /\n", agent: "go-nate", want: 0},
+	}
+
+	for _, c := range cases {
+		if got := Parse([]byte(c.body), c.agent).CrawlDelay(); got != c.want {
+			t.Errorf("%s: CrawlDelay() = %s, want %s in\n%s", c.agent, got, c.want, c.body)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{pattern: "/", path: "/", want: true},
+		{pattern: "/fish", path: "/fish.html", want: true},
+		{pattern: "/fish", path: "/Fish", want: false},
+		{pattern: "/fish*", path: "/fishheads/yummy", want: true},
+		{pattern: "/*.php", path: "/folder/index.php?q=1", want: true},
+		{pattern: "/*.php$", path: "/index.php", want: true},
+		{pattern: "/*.php$", path: "/index.php?q=1", want: false},
+		{pattern: "/fish$", path: "/fish", want: true},
+		{pattern: "/fish$", path: "/fishes", want: false},
+		{pattern: "/a*b*c", path: "/axxbyyc", want: true},
+		{pattern: "/a*b*c", path: "/axxcyyb", want: false},
+	}
+
+	for _, c := range cases {
+		if got := match(c.pattern, c.path); got != c.want {
+			t.Errorf("match(%q, %q) = %t, want %t", c.pattern, c.path, got, c.want)
+		}
+	}
+}
+
+func TestCacheStatuses(t *testing.T) {
+	cases := []struct {
+		name    string
+		res     *dl.Result
+		err     error
+		allowed bool
+	}{
+		{name: "ok", res: &dl.Result{StatusCode: http.StatusOK, Body: []byte("User-agent: *\nDisallow: /\n")}, allowed: false},
+		{name: "not found", res: &dl.Result{StatusCode: http.StatusNotFound}, allowed: true},
+		{name: "server error", res: &dl.Result{StatusCode: http.StatusServiceUnavailable}, allowed: false},
+		{name: "unreachable", err: errors.New("no such host"), allowed: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var fetched int
+			rc := NewCache(dl.FetcherFunc(func(ctx context.Context, url string) (*dl.Result, error) {
+				fetched++
+				if url != "https://example.com/robots.txt" {
+					t.Errorf("unexpected robots.txt URL %s", url)
+				}
+				return c.res, c.err
+			}), "")
+
+			for i := 0; i < 2; i++ {
+				allowed, err := rc.Allowed(context.Background(), "https://example.com/page")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if allowed != c.allowed {
+					t.Errorf("got allowed %t, want %t", allowed, c.allowed)
+				}
+			}
+			if fetched != 1 {
+				t.Errorf("robots.txt is fetched %d times, want once", fetched)
+			}
+		})
+	}
+}
+
+func TestCacheCancelled(t *testing.T) {
+	var fetched int
+	rc := NewCache(dl.FetcherFunc(func(ctx context.Context, url string) (*dl.Result, error) {
+		fetched++
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return &dl.Result{StatusCode: http.StatusNotFound}, nil
+	}), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := rc.Allowed(ctx, "https://example.com/page")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+
+	// the interrupted fetch isn't cached
+	_, err = rc.Allowed(context.Background(), "https://example.com/page")
+	if err != nil || fetched != 2 {
+		t.Errorf("got %v after %d fetches, want robots.txt fetched again", err, fetched)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	if got := Interval(2, 0); got != 500*time.Millisecond {
+		t.Errorf("Interval(2, 0) = %s, want 500ms", got)
+	}
+	if got := Interval(2, 3*time.Second); got != 3*time.Second {
+		t.Errorf("Interval(2, 3s) = %s, want 3s", got)
+	}
+}
@@ -3,12 +3,18 @@ package server
 import (
 	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
+	"github.com/Neurostep/go-nate/internal/images"
+	"github.com/Neurostep/go-nate/internal/links"
 	"github.com/Neurostep/go-nate/internal/logger"
+	"github.com/Neurostep/go-nate/internal/offline"
+	"github.com/Neurostep/go-nate/internal/urlnorm"
 	"github.com/blevesearch/bleve/v2"
 	bleveHttp "github.com/blevesearch/bleve/v2/http"
 	"github.com/gorilla/mux"
 	"net/http"
+	"regexp"
 	"time"
 )
 
@@ -17,15 +23,36 @@ type (
 		Port   int
 		Logger *logger.Logger
 		Index  bleve.Index
+		// Offline holds the offline snapshots of the bookmarks, nil disables serving them
+		Offline *offline.Store
+		// Images reads the favicons and the preview images of the bookmarks, nil disables serving them
+		Images *images.Reader
 	}
 
 	server struct {
 		http.Server
-		l *logger.Logger
-		i bleve.Index
+		l  *logger.Logger
+		i  bleve.Index
+		os *offline.Store
+		im *images.Reader
 	}
 )
 
+const (
+	// _offlinePolicy keeps the snapshot from running scripts and reaching out anywhere but for the assets
+	// which weren't inlined
+	_offlinePolicy = "sandbox allow-popups allow-popups-to-escape-sandbox; default-src 'none'; " +
+		"img-src data: http: https:; media-src data: http: https:; style-src data: 'unsafe-inline'; font-src data:"
+	// _imagePolicy keeps the SVG image opened on its own from running scripts
+	_imagePolicy = "sandbox; default-src 'none'; style-src 'unsafe-inline'"
+	// images are stored under the hash of their content, so they never change
+	_imageCacheControl = "public, max-age=31536000, immutable"
+)
+
+var (
+	_imageID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
 //go:embed static
 var serverStaticFiles embed.FS
 
@@ -36,8 +63,10 @@ func New(props Props) *server {
 			ReadTimeout:  10 * time.Second,
 			WriteTimeout: 10 * time.Second,
 		},
-		l: props.Logger,
-		i: props.Index,
+		l:  props.Logger,
+		i:  props.Index,
+		os: props.Offline,
+		im: props.Images,
 	}
 
 	router := staticFileRouter()
@@ -54,6 +83,16 @@ func New(props Props) *server {
 	debugHandler.DocIDLookup = docIDLookup
 	router.Handle("/api/debug/{docID}", debugHandler).Methods("GET")
 
+	router.HandleFunc("/api/links", s.linksHandler).Methods("GET")
+
+	if s.os != nil {
+		router.HandleFunc("/api/offline", s.offlineHandler).Methods("GET")
+	}
+
+	if s.im != nil {
+		router.HandleFunc("/api/images/{id}", s.imageHandler).Methods("GET")
+	}
+
 	s.Handler = router
 
 	http.Handle("/", router)
@@ -91,6 +130,96 @@ func (s *server) Run(ctx context.Context) error {
 	return err
 }
 
+// offlineHandler serves the offline snapshot of the bookmark, the URL of which is passed in the "url" parameter.
+func (s *server) offlineHandler(w http.ResponseWriter, r *http.Request) {
+	href := r.URL.Query().Get("url")
+	if href == "" {
+		http.Error(w, "url parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.os.Get(href)
+	if err == offline.ErrNoSnapshot {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.l.Errorf("couldn't read offline snapshot of %s: %s", href, err)
+		http.Error(w, "couldn't read offline snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", _offlinePolicy)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(page)
+}
+
+// linksHandler serves the bookmarks the bookmark links to and the ones linking to it, along with its rank,
+// as of the time it was indexed. The URL of the bookmark is passed in the "url" parameter.
+func (s *server) linksHandler(w http.ResponseWriter, r *http.Request) {
+	href := r.URL.Query().Get("url")
+	if href == "" {
+		http.Error(w, "url parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	req := bleve.NewSearchRequest(bleve.NewDocIDQuery([]string{urlnorm.Key(href)}))
+	req.Fields = []string{"url", "rank", "links_to", "linked_from"}
+	res, err := s.i.Search(req)
+	if err != nil {
+		s.l.Errorf("couldn't look up links of %s: %s", href, err)
+		http.Error(w, "couldn't look up links", http.StatusInternalServerError)
+		return
+	}
+	if len(res.Hits) == 0 {
+		http.Error(w, "bookmark is not indexed", http.StatusNotFound)
+		return
+	}
+
+	f := res.Hits[0].Fields
+	rank, _ := f["rank"].(float64)
+	n := links.Node{
+		URL:        href,
+		Rank:       rank,
+		LinksTo:    fieldStrings(f["links_to"]),
+		LinkedFrom: fieldStrings(f["linked_from"]),
+	}
+	if u := fieldStrings(f["url"]); len(u) > 0 {
+		n.URL = u[0]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&n)
+}
+
+// imageHandler serves the favicon or the preview image by its ID.
+func (s *server) imageHandler(w http.ResponseWriter, r *http.Request) {
+	id := muxVariableLookup(r, "id")
+	if !_imageID.MatchString(id) {
+		http.Error(w, "invalid image id", http.StatusBadRequest)
+		return
+	}
+
+	img, err := s.im.Get(id)
+	if err == images.ErrNoImage {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		// most likely the DB is held by the running dump
+		s.l.Errorf("couldn't read image %s: %s", id, err)
+		http.Error(w, "couldn't read image", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("Cache-Control", _imageCacheControl)
+	w.Header().Set("Content-Security-Policy", _imagePolicy)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(img.Data)
+}
+
 func staticFileRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.StrictSlash(true)
@@ -139,3 +268,21 @@ func muxVariableLookup(req *http.Request, name string) string {
 func docIDLookup(req *http.Request) string {
 	return muxVariableLookup(req, "docID")
 }
+
+// fieldStrings returns the values of the stored field, which is a string if there is only one of them.
+func fieldStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+
+	return []string{}
+}
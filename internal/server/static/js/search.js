@@ -235,7 +235,17 @@ function SearchCtrl($scope, $http, $routeParams, $log, $sce) {
         for(var i in $scope.results.hits) {
                 hit = $scope.results.hits[i];
 
-                hit.title = hit.fields[hit.fields.lang + "_title"] || hit.fields.url
+                // the title is in its own language, the bookmarks dumped before it had one use the content language
+                hit.title = hit.fields[(hit.fields.title_lang || hit.fields.lang) + "_title"] || hit.fields.url
+                if (hit.fields.offline) {
+                    hit.offlineURL = "/api/offline?url=" + encodeURIComponent(hit.fields.url)
+                }
+                if (hit.fields.favicon_id) {
+                    hit.faviconURL = "/api/images/" + hit.fields.favicon_id
+                }
+                if (hit.fields.image_id) {
+                    hit.imageURL = "/api/images/" + hit.fields.image_id
+                }
 
                 hit.roundedScore = $scope.roundScore(hit.score);
                 hit.explanationString = $scope.expl(hit.explanation);
@@ -0,0 +1,239 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/keys"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+type (
+	// Snapshot is the extracted content of the bookmark at the time it was dumped.
+	Snapshot struct {
+		Version     int64  `json:"version"`
+		FetchedAt   string `json:"fetched_at"`
+		Title       string `json:"title"`
+		Text        string `json:"text"`
+		ContentHash string `json:"content_hash"`
+	}
+
+	Store struct {
+		db *badger.DB
+	}
+)
+
+const (
+	_namespace = "snapshot"
+	// versions are zero-padded, so the keys are sorted in the order the snapshots were taken
+	_versionFormat = "%020d"
+)
+
+var (
+	ErrNoSnapshots = errors.New("bookmark has no snapshots")
+)
+
+func New(db *badger.DB) *Store {
+	return &Store{db: db}
+}
+
+// Add stores the snapshot of the bookmark and removes the oldest ones, so at most keep snapshots are left.
+// The snapshot of the content which is the same as the one of the latest snapshot is not stored, so the
+// dumps which haven't found any changes don't push the history out.
+func (s *Store) Add(href string, sn Snapshot, keep int) error {
+	if sn.Version == 0 {
+		sn.Version = time.Now().UnixNano()
+	}
+
+	val, err := json.Marshal(&sn)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		var ks [][]byte
+		err := s.iterate(txn, href, false, func(k []byte, _ []byte) error {
+			ks = append(ks, k)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(ks) > 0 && sn.ContentHash != "" {
+			latest, err := s.get(txn, ks[len(ks)-1])
+			if err != nil {
+				return err
+			}
+			if latest.ContentHash == sn.ContentHash {
+				return nil
+			}
+		}
+
+		k := keys.Key(_namespace, href, fmt.Sprintf(_versionFormat, sn.Version))
+		err = txn.Set(k, val)
+		if err != nil {
+			return err
+		}
+		ks = append(ks, k)
+
+		for i := 0; i < len(ks)-keep; i++ {
+			err = txn.Delete(ks[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *Store) get(txn *badger.Txn, k []byte) (Snapshot, error) {
+	var sn Snapshot
+
+	item, err := txn.Get(k)
+	if err != nil {
+		return sn, err
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &sn)
+	})
+
+	return sn, err
+}
+
+// List returns the snapshots of the bookmark, the oldest first.
+func (s *Store) List(href string) ([]Snapshot, error) {
+	var res []Snapshot
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		return s.iterate(txn, href, true, func(_ []byte, v []byte) error {
+			var sn Snapshot
+			err := json.Unmarshal(v, &sn)
+			if err != nil {
+				return err
+			}
+			res = append(res, sn)
+
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+func (s *Store) iterate(txn *badger.Txn, href string, values bool, fn func(k, v []byte) error) error {
+	prefix := keys.Prefix(_namespace, href)
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = values
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		k := item.KeyCopy(nil)
+		// guard against the other bookmark which URL starts with the same prefix
+		if len(k)-len(prefix) != len(fmt.Sprintf(_versionFormat, 0)) {
+			continue
+		}
+
+		var v []byte
+		if values {
+			var err error
+			v, err = item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := fn(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateKeys moves the snapshots to the bookmark URLs the key function maps their URLs to, e.g. the normalized ones.
+// The number of the moved snapshots is returned.
+func (s *Store) MigrateKeys(key func(href string) string) (int, error) {
+	prefix := keys.Prefix(_namespace)
+	versionLen := len(fmt.Sprintf(_versionFormat, 0))
+	moved := map[string][]byte{}
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			k := it.Item().KeyCopy(nil)
+			if len(k) < len(prefix)+versionLen+1 {
+				continue
+			}
+
+			href := string(k[len(prefix) : len(k)-versionLen-1])
+			if to := key(href); to != href {
+				moved[string(k)] = keys.Key(_namespace, to, string(k[len(k)-versionLen:]))
+			}
+		}
+
+		return nil
+	})
+	if err != nil || len(moved) == 0 {
+		return 0, err
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for from, to := range moved {
+		err = s.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte(from))
+			if err != nil {
+				return err
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			return wb.Set(to, val)
+		})
+		if err != nil {
+			return 0, err
+		}
+		err = wb.Delete([]byte(from))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(moved), wb.Flush()
+}
+
+// Diff returns the unified diff of the text of two snapshots.
+func Diff(from, to Snapshot) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from.Title + "\n\n" + from.Text),
+		B:        difflib.SplitLines(to.Title + "\n\n" + to.Text),
+		FromFile: from.label(),
+		ToFile:   to.label(),
+		Context:  3,
+	})
+}
+
+// Time returns the time the snapshot was taken at.
+func (sn Snapshot) Time() time.Time {
+	return time.Unix(0, sn.Version).UTC()
+}
+
+func (sn Snapshot) label() string {
+	return sn.Time().Format(time.RFC3339)
+}
@@ -0,0 +1,111 @@
+package urlnorm
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	// _trackingParams are the query parameters which don't change the content of the page
+	_trackingParams = map[string]bool{
+		"fbclid":  true,
+		"gclid":   true,
+		"dclid":   true,
+		"msclkid": true,
+		"yclid":   true,
+		"igshid":  true,
+		"mc_cid":  true,
+		"mc_eid":  true,
+		"_hsenc":  true,
+		"_hsmi":   true,
+	}
+
+	_defaultPorts = map[string]string{
+		"http":  "80",
+		"https": "443",
+	}
+)
+
+const (
+	_trackingPrefix = "utm_"
+	// _scheme is the scheme every http(s) URL gets
+	_scheme = "https"
+)
+
+// Normalize brings the URL to the form, which is the same for all the variants of the address
+// of the same page. The result is meant to be used as an identity of the bookmark, not to be fetched:
+//
+//   - scheme and host are lowercased, "http" becomes "https"
+//   - IDN host is converted to punycode
+//   - the port which is the default one of either the scheme or "https" is dropped, e.g. "http://host:443/" is the
+//     same as "https://host/", as well as fragment and the trailing slash of the path
+//   - "utm_*" and other tracking query parameters are dropped, the rest is sorted
+//
+// URLs with the schemes other than http(s) are returned as they are.
+func Normalize(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return raw, nil
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+	// the port is checked against the scheme the URL is given with and the one it's normalized to, so it's
+	// dropped whatever of them it's the default port of
+	if port := u.Port(); port != "" && port != _defaultPorts[scheme] && port != _defaultPorts[_scheme] {
+		host = host + ":" + port
+	}
+
+	u.Scheme = _scheme
+	u.Host = host
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	} else if len(u.Path) > 1 && strings.HasSuffix(u.Path, "/") {
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+		if u.Path == "" {
+			u.Path = "/"
+		}
+	}
+
+	u.RawQuery = normalizeQuery(u.Query())
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// Key returns the normalized URL to identify the bookmark by, the URL itself if it can't be parsed.
+func Key(raw string) string {
+	n, err := Normalize(raw)
+	if err != nil {
+		return raw
+	}
+
+	return n
+}
+
+func normalizeQuery(q url.Values) string {
+	for k := range q {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, _trackingPrefix) || _trackingParams[lk] {
+			delete(q, k)
+		}
+	}
+
+	// Encode sorts by key, values of the same key keep their order as it could matter
+	return q.Encode()
+}
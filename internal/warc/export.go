@@ -0,0 +1,48 @@
+package warc
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Export copies the records at the locations in the WARC directory to the single WARC file, which starts
+// with its own "warcinfo" record. The records are copied as they are, compressed.
+func Export(dir string, out io.Writer, filename string, locs []Location) error {
+	info, err := warcinfo(filename)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(info)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*os.File{}
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	for _, loc := range locs {
+		f, ok := files[loc.File]
+		if !ok {
+			// the name comes from the DB, so it's not trusted to point outside of the directory
+			f, err = os.Open(filepath.Join(dir, filepath.Base(loc.File)))
+			if err != nil {
+				return errors.Wrap(err, "couldn't open WARC file")
+			}
+			files[loc.File] = f
+		}
+
+		_, err = io.Copy(out, io.NewSectionReader(f, loc.Offset, loc.Length))
+		if err != nil {
+			return errors.Wrapf(err, "couldn't copy WARC record from %s at %d", loc.File, loc.Offset)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Writer appends the records to the WARC files in the directory, every record is a separate gzip member,
+	// so it could be read on its own by the offset. The file is rotated once it's about to exceed the size limit.
+	Writer struct {
+		mu      sync.Mutex
+		dir     string
+		maxSize int64
+
+		f       *os.File
+		name    string
+		size    int64
+		seq     int
+		records int
+		started string
+	}
+
+	// Location points at the record in the WARC file.
+	Location struct {
+		// File is the name of the file in the WARC directory
+		File   string `json:"file"`
+		Offset int64  `json:"offset"`
+		// Length is the length of the compressed record
+		Length int64 `json:"length"`
+	}
+
+	// field is the named field of the WARC record header, the order of the fields is kept.
+	field struct {
+		name, value string
+	}
+)
+
+const (
+	// DefaultMaxSize is the size WARC files are usually rotated at
+	DefaultMaxSize = 1 << 30
+
+	_version       = "WARC/1.0"
+	_fileExt       = ".warc.gz"
+	_filePrefix    = "gonate"
+	_dateFormat    = "2006-01-02T15:04:05Z"
+	_fileTimestamp = "20060102150405"
+	_software      = "go-nate"
+	_crlf          = "\r\n"
+)
+
+func NewWriter(dir string, maxSize int64) (*Writer, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create WARC directory")
+	}
+
+	return &Writer{
+		dir:     dir,
+		maxSize: maxSize,
+		started: time.Now().UTC().Format(_fileTimestamp),
+	}, nil
+}
+
+// WriteResponse writes the response the HTTP loader received as the "response" record. The body is the one
+// the loader has read, so Content-Length is set to its size, as the transport might have decompressed it.
+func (w *Writer) WriteResponse(res *dl.Result, date time.Time) (Location, error) {
+	target := res.FinalURL
+	if target == "" {
+		target = res.URL
+	}
+
+	var block bytes.Buffer
+	fmt.Fprintf(&block, "HTTP/1.1 %d %s%s", res.StatusCode, http.StatusText(res.StatusCode), _crlf)
+	h := res.Header.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Del("Transfer-Encoding")
+	h.Set("Content-Length", strconv.Itoa(len(res.Body)))
+	err := h.Write(&block)
+	if err != nil {
+		return Location{}, err
+	}
+	block.WriteString(_crlf)
+	block.Write(res.Body)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.write([]field{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", recordID()},
+		{"WARC-Date", date.UTC().Format(_dateFormat)},
+		{"WARC-Target-URI", target},
+		{"WARC-Payload-Digest", digest(res.Body)},
+		{"WARC-Block-Digest", digest(block.Bytes())},
+		{"Content-Type", "application/http; msgtype=response"},
+	}, block.Bytes())
+}
+
+// Close closes the current file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+
+	return err
+}
+
+// write puts the record into the current file, opening the next one if it's needed.
+func (w *Writer) write(fields []field, block []byte) (Location, error) {
+	rec, err := record(fields, block)
+	if err != nil {
+		return Location{}, err
+	}
+
+	// the record larger than the limit is still written, to the file of its own
+	if w.f == nil || (w.records > 0 && w.size+int64(len(rec)) > w.maxSize) {
+		err = w.rotate()
+		if err != nil {
+			return Location{}, err
+		}
+	}
+	loc := Location{File: w.name, Offset: w.size, Length: int64(len(rec))}
+	_, err = w.f.Write(rec)
+	if err != nil {
+		return Location{}, errors.Wrapf(err, "couldn't write WARC record to %s", w.name)
+	}
+	w.size += int64(len(rec))
+	w.records++
+
+	return loc, nil
+}
+
+// rotate closes the current file and opens the next one, starting it with the "warcinfo" record.
+func (w *Writer) rotate() error {
+	if w.f != nil {
+		err := w.f.Close()
+		if err != nil {
+			return err
+		}
+		w.f = nil
+	}
+
+	w.seq++
+	w.name = fmt.Sprintf("%s-%s-%05d%s", _filePrefix, w.started, w.seq, _fileExt)
+	f, err := os.OpenFile(filepath.Join(w.dir, w.name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create WARC file")
+	}
+	w.f, w.size, w.records = f, 0, 0
+
+	info, err := warcinfo(w.name)
+	if err != nil {
+		return err
+	}
+	_, err = w.f.Write(info)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't write WARC record to %s", w.name)
+	}
+	w.size = int64(len(info))
+
+	return nil
+}
+
+// warcinfo builds the compressed "warcinfo" record describing the file.
+func warcinfo(filename string) ([]byte, error) {
+	block := []byte("software: " + _software + _crlf + "format: WARC File Format 1.0" + _crlf)
+
+	return record([]field{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", recordID()},
+		{"WARC-Date", time.Now().UTC().Format(_dateFormat)},
+		{"WARC-Filename", filename},
+		{"Content-Type", "application/warc-fields"},
+	}, block)
+}
+
+// record formats the record and compresses it as a separate gzip member.
+func record(fields []field, block []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+
+	var head bytes.Buffer
+	head.WriteString(_version + _crlf)
+	for _, f := range fields {
+		head.WriteString(f.name + ": " + f.value + _crlf)
+	}
+	head.WriteString("Content-Length: " + strconv.Itoa(len(block)) + _crlf + _crlf)
+
+	for _, b := range [][]byte{head.Bytes(), block, []byte(_crlf + _crlf)} {
+		_, err := zw.Write(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func recordID() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	// version 4, variant 10
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,191 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neurostep/go-nate/internal/dl"
+)
+
+func response(url, body string) *dl.Result {
+	h := http.Header{}
+	h.Set("Content-Type", "text/html")
+	h.Set("Transfer-Encoding", "chunked")
+
+	return &dl.Result{URL: url, StatusCode: http.StatusOK, Header: h, Body: []byte(body)}
+}
+
+// readRecord reads the single record at the location, it fails if the location doesn't point at the whole
+// gzip member.
+func readRecord(t *testing.T, dir string, loc Location) string {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join(dir, loc.File))
+	if err != nil {
+		t.Fatalf("couldn't open %s: %v", loc.File, err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(io.NewSectionReader(f, loc.Offset, loc.Length))
+	if err != nil {
+		t.Fatalf("no gzip member at %s:%d: %v", loc.File, loc.Offset, err)
+	}
+	zr.Multistream(false)
+	rec, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("couldn't read record at %s:%d: %v", loc.File, loc.Offset, err)
+	}
+
+	return string(rec)
+}
+
+func TestWriteResponseOffsets(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pages := map[string]string{
+		"https://example.com/a": "<p>first</p>",
+		"https://example.com/b": strings.Repeat("second ", 1000),
+		"https://example.com/c": "",
+	}
+	locs := map[string]Location{}
+	for _, u := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+		loc, err := w.WriteResponse(response(u, pages[u]), time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		locs[u] = loc
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a, b, c := locs["https://example.com/a"], locs["https://example.com/b"], locs["https://example.com/c"]
+	if a.Offset == 0 {
+		t.Errorf("the first record overlaps warcinfo")
+	}
+	if b.Offset != a.Offset+a.Length || c.Offset != b.Offset+b.Length || a.File != c.File {
+		t.Errorf("records aren't contiguous: %+v %+v %+v", a, b, c)
+	}
+	st, err := os.Stat(filepath.Join(dir, c.File))
+	if err != nil || st.Size() != c.Offset+c.Length {
+		t.Errorf("file size doesn't match the last record: %v", err)
+	}
+
+	for u, loc := range locs {
+		rec := readRecord(t, dir, loc)
+		for _, want := range []string{
+			"WARC/1.0\r\n",
+			"WARC-Type: response\r\n",
+			"WARC-Target-URI: " + u + "\r\n",
+			"WARC-Date: 2021-05-01T00:00:00Z\r\n",
+		} {
+			if !strings.Contains(rec, want) {
+				t.Errorf("record of %s has no %q", u, strings.TrimSpace(want))
+			}
+		}
+		if !strings.HasSuffix(rec, pages[u]+"\r\n\r\n") {
+			t.Errorf("record of %s doesn't end with its body", u)
+		}
+
+		// the HTTP message is parsed as it is, by the length of the body the loader has read
+		block := rec[strings.Index(rec, "\r\n\r\n")+4:]
+		res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(block)), nil)
+		if err != nil {
+			t.Fatalf("couldn't parse HTTP response of %s: %v", u, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		if string(body) != pages[u] || len(res.TransferEncoding) > 0 {
+			t.Errorf("HTTP response of %s doesn't match the page", u)
+		}
+	}
+}
+
+func TestWriterRotates(t *testing.T) {
+	dir := t.TempDir()
+	// every record exceeds the limit, so it goes to the file of its own
+	w, err := NewWriter(dir, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Close()
+
+	var locs []Location
+	for _, u := range []string{"https://example.com/a", "https://example.com/b"} {
+		loc, err := w.WriteResponse(response(u, "page "+u), time.Now())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		locs = append(locs, loc)
+	}
+
+	if locs[0].File == locs[1].File {
+		t.Fatalf("file isn't rotated: %+v", locs)
+	}
+	for _, loc := range locs {
+		if !strings.HasSuffix(loc.File, _fileExt) || loc.Offset == 0 {
+			t.Errorf("record doesn't follow warcinfo of its own file: %+v", loc)
+		}
+		info := readRecord(t, dir, Location{File: loc.File, Length: loc.Offset})
+		if !strings.Contains(info, "WARC-Type: warcinfo\r\n") || !strings.Contains(info, "WARC-Filename: "+loc.File) {
+			t.Errorf("file %s doesn't start with its warcinfo", loc.File)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var locs []Location
+	for _, u := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+		loc, err := w.WriteResponse(response(u, "page "+u), time.Now())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		locs = append(locs, loc)
+	}
+	w.Close()
+
+	var out bytes.Buffer
+	// the records are exported in the order they are asked for, the name of the file is kept in the directory
+	err = Export(dir, &out, "export.warc.gz", []Location{locs[2], locs[0]})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	all, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("export isn't the valid gzip stream: %v", err)
+	}
+	s := string(all)
+	info, c, a := strings.Index(s, "WARC-Filename: export.warc.gz"), strings.Index(s, "https://example.com/c"), strings.Index(s, "https://example.com/a")
+	if info < 0 || c < info || a < c || strings.Contains(s, "https://example.com/b") {
+		t.Errorf("unexpected export:\n%s", s)
+	}
+
+	// the location pointing outside of the directory isn't followed
+	err = Export(dir, ioutil.Discard, "x.warc.gz", []Location{{File: "../../etc/passwd", Length: 1}})
+	if err == nil {
+		t.Errorf("location outside of the directory is exported")
+	}
+}
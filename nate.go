@@ -2,25 +2,40 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/Neurostep/go-nate/internal/dl"
 	"github.com/Neurostep/go-nate/internal/dump"
+	"github.com/Neurostep/go-nate/internal/failed"
+	"github.com/Neurostep/go-nate/internal/health"
+	"github.com/Neurostep/go-nate/internal/images"
 	"github.com/Neurostep/go-nate/internal/indexer"
+	"github.com/Neurostep/go-nate/internal/links"
 	"github.com/Neurostep/go-nate/internal/logger"
+	"github.com/Neurostep/go-nate/internal/offline"
+	"github.com/Neurostep/go-nate/internal/progress"
 	"github.com/Neurostep/go-nate/internal/repl"
+	"github.com/Neurostep/go-nate/internal/robots"
 	"github.com/Neurostep/go-nate/internal/server"
+	"github.com/Neurostep/go-nate/internal/snapshot"
+	"github.com/Neurostep/go-nate/internal/urlnorm"
 	ua "github.com/Neurostep/go-nate/internal/user-agents"
+	"github.com/Neurostep/go-nate/internal/warc"
 	"github.com/blevesearch/bleve/v2"
 	"github.com/dgraph-io/badger/v3"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffcli"
 
 	"github.com/konoui/alfred-bookmarks/pkg/bookmarker"
@@ -38,6 +53,24 @@ const (
 	_chromeBrowser  = "chrome"
 	_safariBrowser  = "safari"
 	_firefoxBrowser = "firefox"
+
+	_defaultKeepSnapshots = 5
+
+	_outputTable = "table"
+	_outputJson  = "json"
+
+	_progressBar  = "bar"
+	_progressJson = "json"
+	_progressNone = "none"
+
+	// _planTopHosts is the number of the hosts with the most bookmarks listed by the dump plan
+	_planTopHosts = 10
+
+	_warcDir    = "warc"
+	_offlineDir = "offline"
+
+	// _imagesIdle is how long the server keeps the DB open once the images are not requested anymore
+	_imagesIdle = time.Second * 10
 )
 
 func main() {
@@ -45,12 +78,21 @@ func main() {
 		debug                      bool
 		logPath, dbPath, indexPath string
 
-		rootFlagSet   = flag.NewFlagSet("go-nate", flag.ExitOnError)
-		dumpFlagSet   = flag.NewFlagSet("dump", flag.ExitOnError)
-		indexFlagSet  = flag.NewFlagSet("index", flag.ExitOnError)
-		watchFlagSet  = flag.NewFlagSet("watch", flag.ExitOnError)
-		serverFlagSet = flag.NewFlagSet("server", flag.ExitOnError)
-		replFlagSet   = flag.NewFlagSet("repl", flag.ExitOnError)
+		rootFlagSet       = flag.NewFlagSet("go-nate", flag.ExitOnError)
+		dumpFlagSet       = flag.NewFlagSet("dump", flag.ExitOnError)
+		indexFlagSet      = flag.NewFlagSet("index", flag.ExitOnError)
+		watchFlagSet      = flag.NewFlagSet("watch", flag.ExitOnError)
+		serverFlagSet     = flag.NewFlagSet("server", flag.ExitOnError)
+		replFlagSet       = flag.NewFlagSet("repl", flag.ExitOnError)
+		historyFlagSet    = flag.NewFlagSet("history", flag.ExitOnError)
+		diffFlagSet       = flag.NewFlagSet("diff", flag.ExitOnError)
+		checkFlagSet      = flag.NewFlagSet("check", flag.ExitOnError)
+		dedupeFlagSet     = flag.NewFlagSet("dedupe", flag.ExitOnError)
+		failuresFlagSet   = flag.NewFlagSet("failures", flag.ExitOnError)
+		linksFlagSet      = flag.NewFlagSet("links", flag.ExitOnError)
+		langFlagSet       = flag.NewFlagSet("lang", flag.ExitOnError)
+		warcFlagSet       = flag.NewFlagSet("warc", flag.ExitOnError)
+		warcExportFlagSet = flag.NewFlagSet("export", flag.ExitOnError)
 	)
 
 	rootFlagSet.BoolVar(&debug, "d", false, "Turn on debug mode")
@@ -88,11 +130,24 @@ func main() {
 		if err != nil {
 			return nil, err
 		}
+		if readOnly {
+			return db, nil
+		}
+
+		// the bookmarks dumped before the URLs were normalized are stored under their raw URLs
+		n, err := dump.MigrateKeys(db)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("couldn't migrate bookmarks to normalized keys: %w", err)
+		}
+		if n > 0 {
+			rootLogger.Infof("migrated %d bookmarks to normalized keys", n)
+		}
 
 		return db, nil
 	}
 
-	initBookmarkManager := func(browser, path, profile *string) (bookmarker.Bookmarker, error) {
+	bookmarkerOption := func(browser, path, profile *string) bookmarker.Option {
 		var opt bookmarker.Option
 		switch *browser {
 		case _chromeBrowser:
@@ -115,7 +170,11 @@ func main() {
 			opt = bookmarker.OptionSafari()
 		}
 
-		manager, err := bookmarker.New(opt)
+		return opt
+	}
+
+	initBookmarkManager := func(browser, path, profile *string) (bookmarker.Bookmarker, error) {
+		manager, err := bookmarker.New(bookmarkerOption(browser, path, profile))
 		if err != nil {
 			return nil, err
 		}
@@ -123,15 +182,6 @@ func main() {
 		return manager, nil
 	}
 
-	var dumpBookmarksPath, dumpBrowser, dumpBrowserProfile string
-	var dumpConcurrency int
-	var forceDump bool
-	dumpFlagSet.StringVar(&dumpBookmarksPath, "f", _chromeDataPath, "The path to local browser profile")
-	dumpFlagSet.StringVar(&dumpBrowser, "b", _chromeBrowser, "Browser for which bookmarks are being dumped")
-	dumpFlagSet.StringVar(&dumpBrowserProfile, "p", _chromeProfileName, "The profile name of the browser")
-	dumpFlagSet.IntVar(&dumpConcurrency, "c", 100, "Number of concurrent workers to dump the bookmarks")
-	dumpFlagSet.BoolVar(&forceDump, "F", false, "If provided, then bookmark will be dumped even if it already exists")
-
 	uaStream, err := ua.NewRandomStream()
 	if err != nil {
 		rootLogger.Fatalf("fatal: couldn't initialize ua reader %s", err)
@@ -143,12 +193,225 @@ func main() {
 		}
 	}()
 
+	// initNetwork returns the options of the loaders, which make them to reach the hosts according
+	// to their network profiles and to authenticate the requests
+	initNetwork := func(cookiesPath, headersPath, profilesPath string) ([]dl.Option, error) {
+		var opts []dl.Option
+
+		if cookiesPath != "" || headersPath != "" {
+			creds, err := dl.LoadCredentials(cookiesPath, headersPath)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, dl.OptCredentials(creds))
+		}
+
+		if profilesPath != "" {
+			profiles, err := dl.LoadProfiles(profilesPath)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, dl.OptProfiles(profiles))
+		}
+
+		return opts, nil
+	}
+
+	initFetcher := func(chainSpec string, policy dl.RetryPolicy, limits dl.Limits, network []dl.Option) (dl.Fetcher, func(), error) {
+		network = append([]dl.Option{dl.OptLimits(limits)}, network...)
+		httpL := dl.NewHttpLoader(append([]dl.Option{dl.OptUserAgentSource(uaStream), dl.OptRetryPolicy(policy)}, network...)...)
+		chromeL := dl.NewChromeLoader(append([]dl.Option{dl.OptRetryPolicy(policy)}, network...)...)
+
+		chain, err := dl.ParseChain(chainSpec, map[string]dl.Fetcher{
+			dl.HttpLoaderName:   httpL,
+			dl.ChromeLoaderName: chromeL,
+		})
+		if err != nil {
+			chromeL.Stop()
+			return nil, nil, err
+		}
+
+		return chain, chromeL.Stop, nil
+	}
+
+	initRobots := func(ignore bool, agent string, network []dl.Option) *robots.Cache {
+		if ignore {
+			return nil
+		}
+
+		return robots.NewCache(dl.NewHttpLoader(append([]dl.Option{dl.OptUserAgent(agent)}, network...)...), agent)
+	}
+
+	// initPolite returns the network options along with the ones making the loaders to follow robots.txt and
+	// the crawl delays of the hosts, the robots cache is nil if robots.txt is ignored. The User-Agent carries
+	// the agent the rules are matched by. The loaders reaching the same hosts have to share the options.
+	initPolite := func(rc *robots.Cache, agent string, network []dl.Option) []dl.Option {
+		opts := append([]dl.Option{}, network...)
+		opts = append(opts, dl.OptGuard(robots.NewGuard(rc, robots.NewHostLimiters(rc, dump.DefaultRateLimit))))
+		if rc != nil {
+			if agent == "" {
+				agent = robots.DefaultAgent
+			}
+			opts = append(opts, dl.OptProductToken(agent))
+		}
+
+		return opts
+	}
+
+	initProgress := func(kind string) (progress.Sink, error) {
+		switch kind {
+		case _progressBar:
+			return progress.NewBar(), nil
+		case _progressJson:
+			return progress.NewJSONLines(os.Stdout), nil
+		case _progressNone:
+			return progress.Discard, nil
+		}
+
+		return nil, fmt.Errorf("progress must be one of '%s', '%s' or '%s', got %q", _progressBar, _progressJson, _progressNone, kind)
+	}
+
+	initRetryPolicy := func(attempts int, statuses string, deadline time.Duration) (dl.RetryPolicy, error) {
+		codes, err := dl.ParseStatuses(statuses)
+		if err != nil {
+			return dl.RetryPolicy{}, err
+		}
+
+		policy := dl.DefaultRetryPolicy
+		policy.MaxAttempts = attempts
+		policy.Statuses = codes
+		policy.Deadline = deadline
+
+		return policy, nil
+	}
+
+	var dumpBookmarksPath, dumpBrowser, dumpBrowserProfile, dumpFetchChain, dumpRobotsAgent, dumpRetryStatuses string
+	var dumpCookiesPath, dumpHeadersPath, dumpProfilesPath, dumpProgress string
+	var dumpConcurrency, dumpKeepSnapshots, dumpRetryAttempts int
+	var dumpRetryDeadline, dumpConnectTimeout, dumpHeaderTimeout, dumpTimeout time.Duration
+	var forceDump, refreshDump, dumpIgnoreRobots, dumpRetryFailed, dumpResume, dumpWarc, dumpOffline, dumpImages, dumpDryRun bool
+	var dumpWarcSize, dumpOfflineBudget, dumpMaxSize, dumpImageSize int64
+	var dumpConfigPath string
+	var dumpFolders, dumpExcludeFolders, dumpDomains, dumpExcludeDomains, dumpMatch stringsFlag
+	dumpFlagSet.StringVar(&dumpBookmarksPath, "f", _chromeDataPath, "The path to local browser profile")
+	dumpFlagSet.StringVar(&dumpBrowser, "b", _chromeBrowser, "Browser for which bookmarks are being dumped")
+	dumpFlagSet.StringVar(&dumpBrowserProfile, "p", _chromeProfileName, "The profile name of the browser")
+	dumpFlagSet.IntVar(&dumpConcurrency, "c", 100, "Number of concurrent workers to dump the bookmarks")
+	dumpFlagSet.BoolVar(&forceDump, "F", false, "If provided, then bookmark will be dumped even if it already exists")
+	dumpFlagSet.IntVar(&dumpKeepSnapshots, "keep", _defaultKeepSnapshots, "Number of content snapshots kept per bookmark, 0 disables snapshots")
+	dumpFlagSet.BoolVar(&refreshDump, "refresh", false, "If provided, then existing bookmarks are re-fetched with conditional requests and saved only if they have changed")
+	dumpFlagSet.BoolVar(&dumpRetryFailed, "retry-failed", false, "If provided, then only the bookmarks which have failed to dump before are dumped again")
+	dumpFlagSet.BoolVar(&dumpResume, "resume", false, "If provided, then the interrupted dump is continued with the options it was started with")
+	dumpFlagSet.BoolVar(&dumpDryRun, "dry-run", false, "If provided, then nothing is dumped, the bookmarks which are new, refreshed, skipped or unfetchable are listed instead")
+	dumpFlagSet.BoolVar(&dumpWarc, "warc", false, "If provided, then the responses received over HTTP are archived to WARC files")
+	dumpFlagSet.Int64Var(&dumpWarcSize, "warc-size", warc.DefaultMaxSize>>20, "Size in megabytes WARC files are rotated at")
+	dumpFlagSet.BoolVar(&dumpOffline, "offline", false, "If provided, then self-contained snapshots with the images and stylesheets inlined are saved for offline reading")
+	dumpFlagSet.Int64Var(&dumpOfflineBudget, "offline-budget", offline.DefaultBudget>>20, "Size in megabytes of the assets inlined into a single offline snapshot")
+	dumpFlagSet.BoolVar(&dumpImages, "images", false, "If provided, then the favicons of the sites and the preview images of the pages are saved to be shown along with the search results")
+	dumpFlagSet.Int64Var(&dumpImageSize, "image-size", images.DefaultMaxImageSize>>10, "Size in kilobytes of the preview image beyond which it's not saved")
+	dumpFlagSet.StringVar(&dumpProgress, "progress", _progressBar, "How the progress is reported: 'bar', 'json' for the JSON line per event on stdout, or 'none'")
+	dumpFlagSet.StringVar(&dumpFetchChain, "fetch", dl.DefaultChainSpec, "Ordered fetchers with their fallback conditions, e.g. 'http,chrome:non200+empty+short=512'")
+	dumpFlagSet.StringVar(&dumpCookiesPath, "cookies", "", "The path to Netscape cookies.txt file, the cookies are sent to their domains only")
+	dumpFlagSet.StringVar(&dumpHeadersPath, "headers", "", "The path to the file with '<domain> <Name>: <value>' line per header sent to the domain over HTTPS, by the http fetcher only")
+	dumpFlagSet.StringVar(&dumpProfilesPath, "network", "", "The path to JSON file with network profiles: proxy, CA, client certificate, timeouts and headers per host pattern")
+	dumpFlagSet.BoolVar(&dumpIgnoreRobots, "ignore-robots", false, "If provided, then robots.txt rules and crawl delays are ignored")
+	dumpFlagSet.StringVar(&dumpRobotsAgent, "robots-agent", robots.DefaultAgent, "User agent name used to match robots.txt rules")
+	dumpFlagSet.IntVar(&dumpRetryAttempts, "retry-attempts", dl.DefaultRetryPolicy.MaxAttempts, "Number of attempts to fetch a bookmark by every fetcher, 1 disables retries")
+	dumpFlagSet.StringVar(&dumpRetryStatuses, "retry-status", statusList(dl.DefaultRetryPolicy.Statuses), "Comma separated response codes the fetch is retried on")
+	dumpFlagSet.DurationVar(&dumpRetryDeadline, "retry-deadline", dl.DefaultRetryPolicy.Deadline, "Time limit of all the attempts of a fetcher along with the delays between them")
+	dumpFlagSet.DurationVar(&dumpConnectTimeout, "connect-timeout", dl.DefaultLimits.ConnectTimeout, "Time limit to establish the connection")
+	dumpFlagSet.DurationVar(&dumpHeaderTimeout, "header-timeout", dl.DefaultLimits.HeaderTimeout, "Time limit to wait for the response once the request is sent")
+	dumpFlagSet.DurationVar(&dumpTimeout, "timeout", dl.DefaultLimits.Timeout, "Time limit of a single fetch including reading the body")
+	dumpFlagSet.Int64Var(&dumpMaxSize, "max-size", dl.DefaultLimits.MaxBodySize>>20, "Size in megabytes of the body beyond which the bookmark is not downloaded and only its metadata is kept")
+	dumpFlagSet.Var(&dumpFolders, "folder", "Folder, along with its subfolders, the bookmarks are dumped from, e.g. 'Work/Docs' or '/Bookmarks Bar/Work' from the root, could be repeated")
+	dumpFlagSet.Var(&dumpExcludeFolders, "exclude-folder", "Folder, along with its subfolders, the bookmarks of which are not dumped, could be repeated")
+	dumpFlagSet.Var(&dumpDomains, "domain", "Domain, along with its subdomains, the bookmarks are dumped from, could be repeated")
+	dumpFlagSet.Var(&dumpExcludeDomains, "exclude-domain", "Domain, along with its subdomains, the bookmarks of which are not dumped, e.g. 'localhost', could be repeated")
+	dumpFlagSet.Var(&dumpMatch, "match", "Glob the bookmark URL has to match, e.g. '*.pdf', or regular expression prefixed with 're:', could be repeated")
+	dumpFlagSet.StringVar(&dumpConfigPath, "config", "", "The path to the file with '<flag> <value>' line per flag, e.g. 'exclude-domain localhost', the flags given on the command line take precedence")
+
 	d := &ffcli.Command{
 		Name:       "dump",
-		ShortUsage: "go-nate dump [-f path] [-b browser] [-p profile] [-c concurrency] [-F force to dump] [bookmark url] [bookmark folder] [bookmark title]",
+		ShortUsage: "go-nate dump [-f path] [-b browser] [-p profile] [-c concurrency] [-F force to dump] [-refresh] [-retry-failed] [-resume] [-dry-run] [-folder folder] [-exclude-folder folder] [-domain domain] [-exclude-domain domain] [-match glob|re:regexp] [-config path] [-warc] [-warc-size megabytes] [-offline] [-offline-budget megabytes] [-images] [-image-size kilobytes] [-progress bar|json|none] [-keep snapshots] [-fetch chain] [-cookies path] [-headers path] [-network path] [-ignore-robots] [-robots-agent name] [-retry-attempts n] [-retry-status codes] [-retry-deadline duration] [-connect-timeout duration] [-header-timeout duration] [-timeout duration] [-max-size megabytes] [bookmark url] [bookmark folder] [bookmark title]",
 		ShortHelp:  "Saves bookmarks for the specified browser to the local DB. If bookmark URL is provided, it will dump that one only",
 		FlagSet:    dumpFlagSet,
+		Options:    []ff.Option{ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.PlainParser)},
 		Exec: func(ctx context.Context, args []string) error {
+			filter, err := dump.NewFilter(dump.FilterProps{
+				Folders:        dumpFolders,
+				ExcludeFolders: dumpExcludeFolders,
+				Domains:        dumpDomains,
+				ExcludeDomains: dumpExcludeDomains,
+				Match:          dumpMatch,
+			})
+			if err != nil {
+				return err
+			}
+
+			if dumpDryRun {
+				if len(args) > 0 {
+					return flag.ErrHelp
+				}
+
+				// the plan is read only, so it could be made while the DB is used by the server
+				var db *badger.DB
+				_, err := os.Stat(fmt.Sprintf("%s/%s", home, dbPath))
+				if os.IsNotExist(err) {
+					// nothing is dumped yet, so the empty DB tells the same
+					db, err = badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(rootLogger))
+				} else {
+					db, err = initBadger(true)
+				}
+				if err != nil {
+					return err
+				}
+				defer func() {
+					err := db.Close()
+					if err != nil {
+						rootLogger.Errorf("error: couldn't close db connection %s", err)
+					}
+				}()
+
+				manager, err := initBookmarkManager(&dumpBrowser, &dumpBookmarksPath, &dumpBrowserProfile)
+				if err != nil {
+					return err
+				}
+
+				// robots.txt of the hosts tells their crawl delays
+				network, err := initNetwork(dumpCookiesPath, dumpHeadersPath, dumpProfilesPath)
+				if err != nil {
+					return err
+				}
+
+				d, err := dump.NewDump(&dump.Props{
+					Bm:     manager,
+					Logger: rootLogger,
+					Db:     db,
+					Robots: initRobots(dumpIgnoreRobots, dumpRobotsAgent, network),
+				})
+				if err != nil {
+					return err
+				}
+
+				plan, err := d.Plan(ctx, dump.RunOptions{
+					Force:       forceDump,
+					Refresh:     refreshDump,
+					RetryFailed: dumpRetryFailed,
+					Resume:      dumpResume,
+					Filter:      filter,
+				})
+				if err != nil {
+					return err
+				}
+
+				return printPlan(os.Stdout, plan)
+			}
+
+			sink, err := initProgress(dumpProgress)
+			if err != nil {
+				return err
+			}
+
 			rootLogger.Info("start dumping bookmarks...")
 			defer rootLogger.Info("dump has been finished")
 
@@ -175,18 +438,102 @@ func main() {
 				return err
 			}
 
-			httpL := dl.NewHttpLoader()
-			chromeL := dl.NewChromeLoader()
-			defer chromeL.Stop()
+			policy, err := initRetryPolicy(dumpRetryAttempts, dumpRetryStatuses, dumpRetryDeadline)
+			if err != nil {
+				return err
+			}
+
+			network, err := initNetwork(dumpCookiesPath, dumpHeadersPath, dumpProfilesPath)
+			if err != nil {
+				return err
+			}
+			rc := initRobots(dumpIgnoreRobots, dumpRobotsAgent, network)
+			// the bookmarks, their assets and images are fetched politely alike
+			polite := initPolite(rc, dumpRobotsAgent, network)
+
+			fetcher, stopFetcher, err := initFetcher(dumpFetchChain, policy, dl.Limits{
+				ConnectTimeout: dumpConnectTimeout,
+				HeaderTimeout:  dumpHeaderTimeout,
+				Timeout:        dumpTimeout,
+				MaxBodySize:    dumpMaxSize << 20,
+			}, polite)
+			if err != nil {
+				return err
+			}
+			defer stopFetcher()
+
+			var archive *warc.Writer
+			if dumpWarc {
+				archive, err = warc.NewWriter(filepath.Join(home, _warcDir), dumpWarcSize<<20)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					err := archive.Close()
+					if err != nil {
+						rootLogger.Errorf("error: couldn't close WARC file %s", err)
+					}
+				}()
+			}
+
+			var (
+				inliner      *offline.Inliner
+				offlineStore *offline.Store
+			)
+			if dumpOffline {
+				offlineStore, err = offline.NewStore(filepath.Join(home, _offlineDir))
+				if err != nil {
+					return err
+				}
+				// assets are static, they are either there or not
+				inliner = offline.New(offline.Props{
+					Fetcher: dl.NewHttpLoader(append([]dl.Option{
+						dl.OptUserAgentSource(uaStream),
+						dl.OptRetryPolicy(dl.RetryPolicy{MaxAttempts: 1}),
+						dl.OptLimits(dl.Limits{
+							ConnectTimeout: dumpConnectTimeout,
+							HeaderTimeout:  dumpHeaderTimeout,
+							Timeout:        dumpTimeout,
+							MaxBodySize:    offline.DefaultMaxAssetSize,
+						}),
+					}, polite...)...),
+					Budget: dumpOfflineBudget << 20,
+				})
+			}
+
+			var capturer *images.Capturer
+			if dumpImages {
+				// images are static, they are either there or not
+				capturer = images.New(images.Props{
+					Fetcher: dl.NewHttpLoader(append([]dl.Option{
+						dl.OptUserAgentSource(uaStream),
+						dl.OptRetryPolicy(dl.RetryPolicy{MaxAttempts: 1}),
+						dl.OptLimits(dl.Limits{
+							ConnectTimeout: dumpConnectTimeout,
+							HeaderTimeout:  dumpHeaderTimeout,
+							Timeout:        dumpTimeout,
+							MaxBodySize:    dumpImageSize << 10,
+						}),
+					}, polite...)...),
+					Store:        images.NewStore(db),
+					MaxImageSize: dumpImageSize << 10,
+				})
+			}
 
 			d, err := dump.NewDump(&dump.Props{
-				Bm:              manager,
-				Logger:          l,
-				PoolSize:        dumpConcurrency,
-				UserAgentStream: uaStream,
-				HttpLoader:      httpL,
-				ChromeLoader:    chromeL,
-				Db:              db,
+				Bm:           manager,
+				Logger:       l,
+				PoolSize:     dumpConcurrency,
+				Fetcher:      fetcher,
+				Db:           db,
+				Robots:       rc,
+				Warc:         archive,
+				Offline:      inliner,
+				OfflineStore: offlineStore,
+				Images:       capturer,
+				Progress:     sink,
+
+				KeepSnapshots: dumpKeepSnapshots,
 			})
 			if err != nil {
 				return err
@@ -205,13 +552,20 @@ func main() {
 					Folder:        folder,
 					OriginalTitle: title,
 					Force:         forceDump,
+					Refresh:       refreshDump,
 				})
 				if err != nil {
 					l.Error(err)
 					return err
 				}
 			} else {
-				err = d.Run(ctx, forceDump)
+				err = d.Run(ctx, dump.RunOptions{
+					Force:       forceDump,
+					Refresh:     refreshDump,
+					RetryFailed: dumpRetryFailed,
+					Resume:      dumpResume,
+					Filter:      filter,
+				})
 				if err != nil {
 					return err
 				}
@@ -221,12 +575,20 @@ func main() {
 		},
 	}
 
+	var indexProgress string
+	indexFlagSet.StringVar(&indexProgress, "progress", _progressBar, "How the progress is reported: 'bar', 'json' for the JSON line per event on stdout, or 'none'")
+
 	i := &ffcli.Command{
 		Name:       "index",
-		ShortUsage: "go-nate index [bookmark url]",
+		ShortUsage: "go-nate index [-progress bar|json|none] [bookmark url]",
 		ShortHelp:  "Indexes bookmarks from DB. If 'bookmark url' is provided, it will index only that bookmark",
 		FlagSet:    indexFlagSet,
 		Exec: func(ctx context.Context, args []string) error {
+			sink, err := initProgress(indexProgress)
+			if err != nil {
+				return err
+			}
+
 			rootLogger.Info("start indexing bookmarks...")
 			defer rootLogger.Info("index has been finished")
 
@@ -237,20 +599,28 @@ func main() {
 				return err
 			}
 
-			bmIndex, err := bleve.Open(fmt.Sprintf("%s/%s", home, indexPath))
-			if err == bleve.ErrorIndexPathDoesNotExist {
-				indexMapping, err := indexer.BuildIndexMapping()
+			path := fmt.Sprintf("%s/%s", home, indexPath)
+			// stale is the index created with the outdated mapping, it's rebuilt from scratch
+			var stale bool
+			bmIndex, err := bleve.Open(path)
+			if err == nil {
+				err = indexer.CheckMapping(bmIndex)
+				if err == indexer.ErrStaleMapping {
+					l.Infof("index is created with the outdated mapping, it's rebuilt")
+					stale = true
+					err = bmIndex.Close()
+				}
 				if err != nil {
-					l.Errorf("couldn't build index mapping %s", err)
+					l.Errorf("couldn't initialize index %s", err)
 					return err
 				}
-
-				bmIndex, err = bleve.New(fmt.Sprintf("%s/%s", home, indexPath), indexMapping)
+			}
+			if err == bleve.ErrorIndexPathDoesNotExist || stale {
+				bmIndex, err = indexer.Create(path)
 				if err != nil {
 					l.Errorf("couldn't create index %s", err)
 					return err
 				}
-
 			} else if err != nil {
 				l.Errorf("couldn't initialize index %s", err)
 				return err
@@ -272,9 +642,10 @@ func main() {
 					rootLogger.Errorf("error: couldn't close db connection %s", err)
 				}
 			}()
-			id := indexer.New(bmIndex, db, l)
+			id := indexer.New(bmIndex, db, l, sink)
 
-			if len(args) == 1 {
+			// the rebuilt index gets all the bookmarks back
+			if len(args) == 1 && !stale {
 				err = id.IndexBookmark(args[0])
 				if err != nil {
 					l.Error(err)
@@ -293,15 +664,25 @@ func main() {
 	}
 
 	var watchInterval time.Duration
-	var watchBookmarksPath, watchBrowser, watchBrowserProfile string
+	var watchBookmarksPath, watchBrowser, watchBrowserProfile, watchFetchChain, watchRobotsAgent string
+	var watchCookiesPath, watchHeadersPath, watchProfilesPath string
+	var watchIgnoreRobots bool
+	var watchKeepSnapshots int
 	watchFlagSet.DurationVar(&watchInterval, "i", time.Second*30, "The interval in which watch will perform the bookmark file check")
 	watchFlagSet.StringVar(&watchBookmarksPath, "f", _chromeDataPath, "The path to local browser profile")
 	watchFlagSet.StringVar(&watchBrowser, "b", _chromeBrowser, "Browser for which bookmarks are being watched and dumped")
 	watchFlagSet.StringVar(&watchBrowserProfile, "p", _chromeProfileName, "The profile name of the browser")
+	watchFlagSet.StringVar(&watchFetchChain, "fetch", dl.DefaultChainSpec, "Ordered fetchers with their fallback conditions, e.g. 'http,chrome:non200+empty+short=512'")
+	watchFlagSet.StringVar(&watchCookiesPath, "cookies", "", "The path to Netscape cookies.txt file, the cookies are sent to their domains only")
+	watchFlagSet.StringVar(&watchHeadersPath, "headers", "", "The path to the file with '<domain> <Name>: <value>' line per header sent to the domain over HTTPS, by the http fetcher only")
+	watchFlagSet.StringVar(&watchProfilesPath, "network", "", "The path to JSON file with network profiles: proxy, CA, client certificate, timeouts and headers per host pattern")
+	watchFlagSet.IntVar(&watchKeepSnapshots, "keep", _defaultKeepSnapshots, "Number of content snapshots kept per bookmark, 0 disables snapshots")
+	watchFlagSet.BoolVar(&watchIgnoreRobots, "ignore-robots", false, "If provided, then robots.txt rules and crawl delays are ignored")
+	watchFlagSet.StringVar(&watchRobotsAgent, "robots-agent", robots.DefaultAgent, "User agent name used to match robots.txt rules")
 
 	w := &ffcli.Command{
 		Name:       "watch",
-		ShortUsage: "go-nate watch [-i interval] [-f path] [-b browser] [-p profile]",
+		ShortUsage: "go-nate watch [-i interval] [-f path] [-b browser] [-p profile] [-keep snapshots] [-fetch chain] [-cookies path] [-headers path] [-network path] [-ignore-robots] [-robots-agent name]",
 		ShortHelp:  "Runs a background check for the bookmark file change",
 		FlagSet:    watchFlagSet,
 		Exec: func(ctx context.Context, args []string) error {
@@ -364,9 +745,17 @@ func main() {
 				return err
 			}
 
-			httpL := dl.NewHttpLoader()
-			chromeL := dl.NewChromeLoader()
-			defer chromeL.Stop()
+			network, err := initNetwork(watchCookiesPath, watchHeadersPath, watchProfilesPath)
+			if err != nil {
+				return err
+			}
+			rc := initRobots(watchIgnoreRobots, watchRobotsAgent, network)
+
+			fetcher, stopFetcher, err := initFetcher(watchFetchChain, dl.DefaultRetryPolicy, dl.DefaultLimits, initPolite(rc, watchRobotsAgent, network))
+			if err != nil {
+				return err
+			}
+			defer stopFetcher()
 
 			db, err := initBadger(false)
 			if err != nil {
@@ -380,13 +769,15 @@ func main() {
 			}()
 
 			d, err := dump.NewDump(&dump.Props{
-				Bm:              manager,
-				Logger:          dumpLogger,
-				PoolSize:        dumpConcurrency,
-				UserAgentStream: uaStream,
-				HttpLoader:      httpL,
-				ChromeLoader:    chromeL,
-				Db:              db,
+				Bm:       manager,
+				Logger:   dumpLogger,
+				PoolSize: dumpConcurrency,
+				Fetcher:  fetcher,
+				Db:       db,
+				Robots:   rc,
+				Progress: progress.NewBar(),
+
+				KeepSnapshots: watchKeepSnapshots,
 			})
 			if err != nil {
 				return err
@@ -395,14 +786,21 @@ func main() {
 			bmIndex, err := bleve.OpenUsing(fmt.Sprintf("%s/%s", home, indexPath), map[string]interface{}{
 				"read_only": true,
 			})
+			if err != nil {
+				return err
+			}
 			defer func() {
 				err := bmIndex.Close()
 				if err != nil {
 					indexLogger.Error(err)
 				}
 			}()
+			err = indexer.CheckMapping(bmIndex)
+			if err != nil {
+				return err
+			}
 
-			id := indexer.New(bmIndex, db, indexLogger)
+			id := indexer.New(bmIndex, db, indexLogger, nil)
 
 			errs := make(chan error)
 			done := make(chan bool)
@@ -438,7 +836,7 @@ func main() {
 						}
 						evs = 0
 						inAction = true
-						err := d.Run(ctx, false)
+						err := d.Run(ctx, dump.RunOptions{})
 						if err != nil {
 							errs <- err
 							break Loop
@@ -493,11 +891,34 @@ func main() {
 				l.Errorf("couldn't open index %s", err)
 				return err
 			}
+			err = indexer.CheckMapping(bmIndex)
+			if err != nil {
+				l.Errorf("couldn't open index %s", err)
+				return err
+			}
+
+			offlineStore, err := offline.NewStore(filepath.Join(home, _offlineDir))
+			if err != nil {
+				return err
+			}
+
+			// the DB is opened only while the images are being requested, so the dump could run along with the server
+			imageReader := images.NewReader(func() (*badger.DB, error) {
+				return initBadger(true)
+			}, _imagesIdle)
+			defer func() {
+				err := imageReader.Close()
+				if err != nil {
+					l.Errorf("couldn't close db connection %s", err)
+				}
+			}()
 
 			srv := server.New(server.Props{
-				Port:   serverPort,
-				Logger: l,
-				Index:  bmIndex,
+				Port:    serverPort,
+				Logger:  l,
+				Index:   bmIndex,
+				Offline: offlineStore,
+				Images:  imageReader,
 			})
 
 			err = srv.Run(ctx)
@@ -520,6 +941,10 @@ func main() {
 				"read_only": true,
 			})
 
+			if err != nil {
+				return err
+			}
+			err = indexer.CheckMapping(bmIndex)
 			if err != nil {
 				return err
 			}
@@ -530,9 +955,550 @@ func main() {
 		},
 	}
 
+	hs := &ffcli.Command{
+		Name:       "history",
+		ShortUsage: "go-nate history <bookmark url>",
+		ShortHelp:  "Lists the content snapshots of the bookmark",
+		FlagSet:    historyFlagSet,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return flag.ErrHelp
+			}
+
+			db, err := initBadger(true)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := db.Close()
+				if err != nil {
+					rootLogger.Errorf("error: couldn't close db connection %s", err)
+				}
+			}()
+
+			snapshots, err := snapshot.New(db).List(urlnorm.Key(args[0]))
+			if err != nil {
+				return err
+			}
+			if len(snapshots) == 0 {
+				return snapshot.ErrNoSnapshots
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "VERSION\tTAKEN AT\tSIZE\tHASH\tTITLE\n")
+			for i, sn := range snapshots {
+				fmt.Fprintf(tw, "%d\t%s\t%d\t%.12s\t%s\n", i+1, sn.Time().Format(time.RFC3339), len(sn.Text), sn.ContentHash, sn.Title)
+			}
+
+			return tw.Flush()
+		},
+	}
+
+	df := &ffcli.Command{
+		Name:       "diff",
+		ShortUsage: "go-nate diff <bookmark url> [version from] [version to]",
+		ShortHelp:  "Shows the difference of the bookmark content between two snapshots, the last two by default",
+		FlagSet:    diffFlagSet,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) < 1 || len(args) > 3 {
+				return flag.ErrHelp
+			}
+
+			db, err := initBadger(true)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := db.Close()
+				if err != nil {
+					rootLogger.Errorf("error: couldn't close db connection %s", err)
+				}
+			}()
+
+			snapshots, err := snapshot.New(db).List(urlnorm.Key(args[0]))
+			if err != nil {
+				return err
+			}
+			if len(snapshots) == 0 {
+				return snapshot.ErrNoSnapshots
+			}
+
+			from, to := len(snapshots)-1, len(snapshots)
+			if from < 1 {
+				from = 1
+			}
+			for i, a := range args[1:] {
+				v, err := strconv.Atoi(a)
+				if err != nil || v < 1 || v > len(snapshots) {
+					return fmt.Errorf("version must be a number from 1 to %d, got %q", len(snapshots), a)
+				}
+				if i == 0 {
+					from = v
+				} else {
+					to = v
+				}
+			}
+
+			diff, err := snapshot.Diff(snapshots[from-1], snapshots[to-1])
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprint(os.Stdout, diff)
+
+			return err
+		},
+	}
+
+	var checkConcurrency int
+	var checkTimeout time.Duration
+	var checkConnectTimeout, checkHeaderTimeout time.Duration
+	var checkMaxSize int64
+	var checkIgnoreRobots bool
+	var checkOutput, checkCookiesPath, checkHeadersPath, checkProfilesPath, checkRobotsAgent string
+	checkFlagSet.IntVar(&checkConcurrency, "c", 100, "Number of concurrent workers to check the bookmarks")
+	checkFlagSet.DurationVar(&checkTimeout, "t", time.Second*30, "Time limit to check a single bookmark")
+	checkFlagSet.StringVar(&checkOutput, "o", _outputTable, "Report format, either 'table' or 'json'")
+	checkFlagSet.StringVar(&checkCookiesPath, "cookies", "", "The path to Netscape cookies.txt file, the same as for dump")
+	checkFlagSet.StringVar(&checkHeadersPath, "headers", "", "The path to the file with '<domain> <Name>: <value>' line per header, the same as for dump")
+	checkFlagSet.StringVar(&checkProfilesPath, "network", "", "The path to JSON file with network profiles, the same as for dump")
+	checkFlagSet.BoolVar(&checkIgnoreRobots, "ignore-robots", false, "If provided, then robots.txt rules and crawl delays are ignored")
+	checkFlagSet.StringVar(&checkRobotsAgent, "robots-agent", robots.DefaultAgent, "User agent name used to match robots.txt rules")
+	checkFlagSet.DurationVar(&checkConnectTimeout, "connect-timeout", dl.DefaultLimits.ConnectTimeout, "Time limit to establish the connection")
+	checkFlagSet.DurationVar(&checkHeaderTimeout, "header-timeout", dl.DefaultLimits.HeaderTimeout, "Time limit to wait for the response once the request is sent")
+	checkFlagSet.Int64Var(&checkMaxSize, "max-size", dl.DefaultLimits.MaxBodySize>>20, "Size in megabytes of the body beyond which it's not downloaded")
+
+	ch := &ffcli.Command{
+		Name:       "check",
+		ShortUsage: "go-nate check [-c concurrency] [-t timeout] [-o table|json] [-cookies path] [-headers path] [-network path] [-ignore-robots] [-robots-agent name] [-connect-timeout duration] [-header-timeout duration] [-max-size megabytes]",
+		ShortHelp:  "Revisits dumped bookmarks, stores their health and prints the report",
+		FlagSet:    checkFlagSet,
+		Exec: func(ctx context.Context, args []string) error {
+			if checkOutput != _outputTable && checkOutput != _outputJson {
+				return flag.ErrHelp
+			}
+
+			rootLogger.Info("start checking bookmarks...")
+			defer rootLogger.Info("check has been finished")
+
+			l, err := logger.New(logger.Props{
+				Cmd: "check", Debug: debug, OutputPaths: []string{fmt.Sprintf("%s/%s/%s.log", home, logPath, "check")},
+			})
+			if err != nil {
+				return err
+			}
+
+			db, err := initBadger(false)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := db.Close()
+				if err != nil {
+					rootLogger.Errorf("error: couldn't close db connection %s", err)
+				}
+			}()
+
+			network, err := initNetwork(checkCookiesPath, checkHeadersPath, checkProfilesPath)
+			if err != nil {
+				return err
+			}
+
+			checker := health.New(health.Props{
+				Logger:   l,
+				PoolSize: checkConcurrency,
+				Fetcher: dl.NewHttpLoader(append([]dl.Option{
+					dl.OptUserAgentSource(uaStream),
+					dl.OptLimits(dl.Limits{
+						ConnectTimeout: checkConnectTimeout,
+						HeaderTimeout:  checkHeaderTimeout,
+						Timeout:        checkTimeout,
+						MaxBodySize:    checkMaxSize << 20,
+					}),
+				}, network...)...),
+				Db:      db,
+				Robots:  initRobots(checkIgnoreRobots, checkRobotsAgent, network),
+				Timeout: checkTimeout,
+			})
+
+			reports, err := checker.Run(ctx)
+			if err != nil {
+				return err
+			}
+
+			if checkOutput == _outputJson {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(reports)
+			}
+
+			counts := map[health.Outcome]int{}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "HEALTH\tSTATUS\tURL\tFINAL URL\n")
+			for _, r := range reports {
+				counts[r.Health]++
+				if r.Health == health.OK {
+					continue
+				}
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", r.Health, r.HttpStatus, r.URL, r.FinalURL)
+			}
+			fmt.Fprintf(tw, "\n")
+			outcomes := make([]string, 0, len(counts))
+			for o := range counts {
+				outcomes = append(outcomes, string(o))
+			}
+			sort.Strings(outcomes)
+			for _, o := range outcomes {
+				fmt.Fprintf(tw, "%s\t%d\n", o, counts[health.Outcome(o)])
+			}
+
+			return tw.Flush()
+		},
+	}
+
+	var dedupeBrowsers, dedupeBookmarksPath, dedupeBrowserProfile, dedupeOutput string
+	dedupeFlagSet.StringVar(&dedupeBrowsers, "b", _chromeBrowser, "Comma separated list of browsers which bookmarks are checked for duplicates")
+	dedupeFlagSet.StringVar(&dedupeBookmarksPath, "f", "", "The path to local browser profile, used only if single browser is provided")
+	dedupeFlagSet.StringVar(&dedupeBrowserProfile, "p", "", "The profile name of the browser, used only if single browser is provided")
+	dedupeFlagSet.StringVar(&dedupeOutput, "o", _outputTable, "Report format, either 'table' or 'json'")
+
+	dd := &ffcli.Command{
+		Name:       "dedupe",
+		ShortUsage: "go-nate dedupe [-b browsers] [-f path] [-p profile] [-o table|json]",
+		ShortHelp:  "Lists bookmarks collapsing into the same normalized URL along with their folders",
+		FlagSet:    dedupeFlagSet,
+		Exec: func(ctx context.Context, args []string) error {
+			if dedupeOutput != _outputTable && dedupeOutput != _outputJson {
+				return flag.ErrHelp
+			}
+
+			browsers := strings.Split(dedupeBrowsers, ",")
+
+			var opts []bookmarker.Option
+			for _, b := range browsers {
+				b := strings.TrimSpace(b)
+				var path, profile string
+				if len(browsers) == 1 {
+					path, profile = dedupeBookmarksPath, dedupeBrowserProfile
+				}
+				opts = append(opts, bookmarkerOption(&b, &path, &profile))
+			}
+
+			manager, err := bookmarker.New(opts...)
+			if err != nil {
+				return err
+			}
+
+			bms, err := manager.Bookmarks()
+			if err != nil {
+				return err
+			}
+
+			groups := dump.FindDuplicates(bms)
+
+			if dedupeOutput == _outputJson {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(groups)
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, g := range groups {
+				fmt.Fprintf(tw, "%s\n", g.URL)
+				for _, b := range g.Bookmarks {
+					fmt.Fprintf(tw, "\t%s\t%s\t%s\n", b.Browser, b.Folder, b.URL)
+				}
+			}
+			fmt.Fprintf(tw, "\n%d bookmarks, %d of them have duplicates\n", len(bms), len(groups))
+
+			return tw.Flush()
+		},
+	}
+
+	var failuresOutput string
+	failuresFlagSet.StringVar(&failuresOutput, "o", _outputTable, "Report format, either 'table' or 'json'")
+
+	fl := &ffcli.Command{
+		Name:       "failures",
+		ShortUsage: "go-nate failures [-o table|json]",
+		ShortHelp:  "Lists bookmarks which have failed to dump, along with the reason. Use 'dump -retry-failed' to dump them again",
+		FlagSet:    failuresFlagSet,
+		Exec: func(ctx context.Context, args []string) error {
+			if failuresOutput != _outputTable && failuresOutput != _outputJson {
+				return flag.ErrHelp
+			}
+
+			db, err := initBadger(true)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := db.Close()
+				if err != nil {
+					rootLogger.Errorf("error: couldn't close db connection %s", err)
+				}
+			}()
+
+			fs, err := failed.New(db).List()
+			if err != nil {
+				return err
+			}
+
+			if failuresOutput == _outputJson {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(fs)
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "LAST TRIED\tATTEMPTS\tURL\tERROR\n")
+			for _, f := range fs {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", f.LastTriedAt, f.Attempts, f.URL, f.Error)
+			}
+			fmt.Fprintf(tw, "\n%d bookmarks have failed\n", len(fs))
+
+			return tw.Flush()
+		},
+	}
+
+	var warcExportOutput string
+	warcExportFlagSet.StringVar(&warcExportOutput, "o", "", "The path of the WARC file to write, '-' for stdout. Defaults to go-nate-<timestamp>.warc.gz")
+
+	we := &ffcli.Command{
+		Name:       "export",
+		ShortUsage: "go-nate warc export [-o path] [bookmark url...]",
+		ShortHelp:  "Writes the archived responses of the bookmarks to the single WARC file, of all of them if no URL is provided",
+		FlagSet:    warcExportFlagSet,
+		Exec: func(ctx context.Context, args []string) error {
+			db, err := initBadger(true)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := db.Close()
+				if err != nil {
+					rootLogger.Errorf("error: couldn't close db connection %s", err)
+				}
+			}()
+
+			locs, err := dump.ArchivedLocations(db, args...)
+			if err != nil {
+				return err
+			}
+
+			path := warcExportOutput
+			if path == "" {
+				path = fmt.Sprintf("go-nate-%s.warc.gz", time.Now().UTC().Format("20060102150405"))
+			}
+
+			out := os.Stdout
+			if path != "-" {
+				out, err = os.Create(path)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					err := out.Close()
+					if err != nil {
+						rootLogger.Errorf("error: couldn't close WARC file %s", err)
+					}
+				}()
+			}
+
+			err = warc.Export(filepath.Join(home, _warcDir), out, filepath.Base(path), locs)
+			if err != nil {
+				return err
+			}
+			if path != "-" {
+				rootLogger.Infof("%d records are exported to %s", len(locs), path)
+			}
+
+			return nil
+		},
+	}
+
+	var linksOutput string
+	var linksAll bool
+	var linksTop int
+	linksFlagSet.StringVar(&linksOutput, "o", _outputTable, "Report format, either 'table' or 'json'")
+	linksFlagSet.BoolVar(&linksAll, "all", false, "If provided, then all the outbound links of the bookmark are listed, not only the ones to the other bookmarks")
+	linksFlagSet.IntVar(&linksTop, "n", 20, "Number of the top ranked bookmarks listed if no bookmark URL is provided")
+
+	ln := &ffcli.Command{
+		Name:       "links",
+		ShortUsage: "go-nate links [-o table|json] [-all] [-n top] [bookmark url]",
+		ShortHelp:  "Lists the bookmarks the bookmark links to and the ones linking to it. If no bookmark URL is provided, lists the top ranked bookmarks",
+		FlagSet:    linksFlagSet,
+		Exec: func(ctx context.Context, args []string) error {
+			if linksOutput != _outputTable && linksOutput != _outputJson || len(args) > 1 {
+				return flag.ErrHelp
+			}
+
+			db, err := initBadger(true)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := db.Close()
+				if err != nil {
+					rootLogger.Errorf("error: couldn't close db connection %s", err)
+				}
+			}()
+
+			store := links.New(db)
+			g, err := store.Graph()
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 0 {
+				nodes := g.Nodes()
+				sort.SliceStable(nodes, func(i, j int) bool {
+					return nodes[i].Rank > nodes[j].Rank
+				})
+				if linksTop > 0 && len(nodes) > linksTop {
+					nodes = nodes[:linksTop]
+				}
+
+				if linksOutput == _outputJson {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					return enc.Encode(nodes)
+				}
+
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintf(tw, "RANK\tLINKED FROM\tLINKS TO\tURL\n")
+				for _, n := range nodes {
+					fmt.Fprintf(tw, "%.3f\t%d\t%d\t%s\n", n.Rank, len(n.LinkedFrom), len(n.LinksTo), n.URL)
+				}
+
+				return tw.Flush()
+			}
+
+			n := g.Node(args[0])
+			if n == nil {
+				return fmt.Errorf("bookmark %s is not dumped", args[0])
+			}
+
+			var outbound []string
+			if linksAll {
+				outbound, err = store.Get(args[0])
+				if err != nil {
+					return err
+				}
+			}
+
+			if linksOutput == _outputJson {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(struct {
+					*links.Node
+					Outbound []string `json:"outbound,omitempty"`
+				}{n, outbound})
+			}
+
+			sections := []string{"links to", "linked from"}
+			urls := [][]string{n.LinksTo, n.LinkedFrom}
+			if linksAll {
+				sections = append(sections, "outbound links")
+				urls = append(urls, outbound)
+			}
+
+			fmt.Fprintf(os.Stdout, "%s\nrank %.3f\n", n.URL, n.Rank)
+			for i, section := range sections {
+				fmt.Fprintf(os.Stdout, "\n%s (%d):\n", section, len(urls[i]))
+				for _, u := range urls[i] {
+					fmt.Fprintf(os.Stdout, "  %s\n", u)
+				}
+			}
+
+			return nil
+		},
+	}
+
+	var langTitle string
+	var langReset bool
+	langFlagSet.StringVar(&langTitle, "title", "", "Language of the bookmark title, if it differs from the one of the content")
+	langFlagSet.BoolVar(&langReset, "reset", false, "If provided, then the languages set manually are dropped and the detected ones are used again")
+
+	lg := &ffcli.Command{
+		Name:       "lang",
+		ShortUsage: "go-nate lang [-title lang] [-reset] <bookmark url> [lang]",
+		ShortHelp:  "Shows the languages of the bookmark content and title, or sets them manually. The languages set manually are kept by the later dumps",
+		FlagSet:    langFlagSet,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return flag.ErrHelp
+			}
+
+			var o dump.LangOverride
+			if len(args) == 2 {
+				o.Lang = args[1]
+			}
+			o.TitleLang = langTitle
+			o.Reset = langReset
+			set := o.Lang != "" || o.TitleLang != "" || o.Reset
+
+			db, err := initBadger(!set)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := db.Close()
+				if err != nil {
+					rootLogger.Errorf("error: couldn't close db connection %s", err)
+				}
+			}()
+
+			d, err := dump.NewDump(&dump.Props{Logger: rootLogger, Db: db})
+			if err != nil {
+				return err
+			}
+
+			var r dump.Record
+			if set {
+				r, err = d.SetLang(args[0], o)
+			} else {
+				r, err = d.Load(args[0])
+				if err == nil && r == nil {
+					err = fmt.Errorf("bookmark %s is not dumped", args[0])
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			titleLang := r.String("title_lang")
+			if titleLang == "" {
+				titleLang = r.String("lang")
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "\tLANG\tCONFIDENCE\tSCRIPT\tSET MANUALLY\n")
+			fmt.Fprintf(tw, "content\t%s\t%.2f\t%s\t%t\n", r.String("lang"), r.Float("lang_confidence"), r.String("lang_script"), r.String("lang_override") != "")
+			fmt.Fprintf(tw, "title\t%s\t%.2f\t\t%t\n", titleLang, r.Float("title_lang_confidence"), r.String("title_lang_override") != "")
+			if set {
+				fmt.Fprintf(tw, "\nrun 'go-nate index %s' to index the bookmark in its languages\n", args[0])
+			}
+
+			return tw.Flush()
+		},
+	}
+
+	wa := &ffcli.Command{
+		Name:        "warc",
+		ShortUsage:  "go-nate warc <subcommand>",
+		ShortHelp:   "Works with the WARC archive of the dumped bookmarks, see 'dump -warc'",
+		FlagSet:     warcFlagSet,
+		Subcommands: []*ffcli.Command{we},
+		Exec: func(ctx context.Context, args []string) error {
+			return flag.ErrHelp
+		},
+	}
+
 	root := &ffcli.Command{
 		ShortUsage:  "go-nate [flags] <command> [<args>]",
-		Subcommands: []*ffcli.Command{d, i, w, s, r},
+		Subcommands: []*ffcli.Command{d, i, w, s, r, hs, df, ch, dd, fl, ln, lg, wa},
 		FlagSet:     rootFlagSet,
 		UsageFunc:   DefaultUsageFunc,
 		Exec: func(ctx context.Context, args []string) error {
@@ -545,6 +1511,16 @@ func main() {
 		rootLogger.Fatalf("fatal: couldn't parse CLI arguments %s", err)
 	}
 
+	if dumpProgress == _progressJson || indexProgress == _progressJson {
+		// the progress events are the only output on stdout, so it could be piped, the log goes to stderr.
+		// The logger is replaced before the command runs, so every part of it gets the one writing to stderr
+		l, err := logger.New(logger.Props{Cmd: "root", Debug: debug, OutputPaths: []string{"stderr"}})
+		if err != nil {
+			rootLogger.Fatalf("fatal: couldn't initialize logger %s", err)
+		}
+		rootLogger = l
+	}
+
 	err = root.Run(ctx)
 	if err != nil {
 		rootLogger.Fatalf("fatal: go-nate has failed %s", err)
@@ -597,6 +1573,18 @@ func DefaultUsageFunc(c *ffcli.Command) string {
 	return strings.TrimSpace(b.String())
 }
 
+// stringsFlag is the flag which could be given more than once, every value of it is kept.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func countFlags(fs *flag.FlagSet) (n int) {
 	fs.VisitAll(func(*flag.Flag) { n++ })
 	return n
@@ -616,3 +1604,61 @@ func homeDir() (home string, err error) {
 	home = filepath.Join(home, ".gonate")
 	return
 }
+
+func statusList(codes []int) string {
+	s := make([]string, 0, len(codes))
+	for _, c := range codes {
+		s = append(s, strconv.Itoa(c))
+	}
+
+	return strings.Join(s, ",")
+}
+
+// printPlan prints the bookmarks of the dump plan by what's going to happen to them, along with the busiest hosts.
+func printPlan(w io.Writer, p *dump.Plan) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	for _, section := range []struct {
+		title string
+		reqs  []dump.DumpRequest
+	}{{"new", p.New}, {"refresh", p.Refresh}, {"skip", p.Skip}} {
+		fmt.Fprintf(tw, "%s (%d):\n", section.title, len(section.reqs))
+		for _, r := range section.reqs {
+			fmt.Fprintf(tw, "  %s\t%s\n", r.Href, r.Folder)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintf(tw, "filtered out (%d)\n\n", p.Filtered)
+
+	fmt.Fprintf(tw, "unfetchable (%d):\n", len(p.Unfetchable))
+	for _, u := range p.Unfetchable {
+		fmt.Fprintf(tw, "  %s\t%s\n", u.Href, u.Reason)
+	}
+	fmt.Fprintln(tw)
+
+	hosts := make([]string, 0, len(p.Hosts))
+	for h := range p.Hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if p.Hosts[hosts[i]] != p.Hosts[hosts[j]] {
+			return p.Hosts[hosts[i]] > p.Hosts[hosts[j]]
+		}
+		return hosts[i] < hosts[j]
+	})
+	if len(hosts) > _planTopHosts {
+		hosts = hosts[:_planTopHosts]
+	}
+
+	fmt.Fprintf(tw, "hosts (%d), the busiest ones:\n", len(p.Hosts))
+	for _, h := range hosts {
+		fmt.Fprintf(tw, "  %s\t%d\n", h, p.Hosts[h])
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintf(tw, "%d bookmarks to dump, which takes at least %s at %d bookmarks per second per host, or at its crawl delay\n",
+		len(p.New)+len(p.Refresh), p.ETA, p.RateLimit)
+
+	return tw.Flush()
+}